@@ -15,6 +15,7 @@ import (
 	"fmt"
 	"os"
 	"io/ioutil"
+	"time"
 
 	"yutopp/cage"
 
@@ -35,6 +36,29 @@ type Config map[string]*struct {
 	ProcPackageType				string `yaml:"proc_package_type"`
 	ProcPackageDebSourceList	string `yaml:"proc_package_deb_source_list"`
 	IsDebugMode					bool `yaml:"is_debug_mode"`
+
+	HistoryPath					string `yaml:"history_path"`
+	HistoryMaxAgeDays			int `yaml:"history_max_age_days"`
+	HistoryMaxRecords			int `yaml:"history_max_records"`
+
+	BuildCachePath				string `yaml:"build_cache_path"`
+	BuildCacheMaxAgeDays		int `yaml:"build_cache_max_age_days"`
+	BuildCacheMaxMegaBytes		uint64 `yaml:"build_cache_max_mega_bytes"`
+
+	UseResultCache				bool `yaml:"use_result_cache"`
+	ResultCacheMaxEntries		int `yaml:"result_cache_max_entries"`
+	ResultCacheMaxMegaBytes		uint64 `yaml:"result_cache_max_mega_bytes"`
+	ResultCacheMaxAgeMinutes	int `yaml:"result_cache_max_age_minutes"`
+
+	BlobStorePath				string `yaml:"blob_store_path"`
+	BlobStoreMaxAgeDays			int `yaml:"blob_store_max_age_days"`
+	BlobStoreMaxMegaBytes		uint64 `yaml:"blob_store_max_mega_bytes"`
+
+	RunParallelism				int `yaml:"run_parallelism"`
+
+	CgroupRootPath				string `yaml:"cgroup_root_path"`
+
+	MaxLimits					torigoya.PhaseLimits `yaml:"max_limits"`
 }
 
 //
@@ -68,6 +92,9 @@ func main() {
 	for _, v := range config {
 		// replace meta string to instance
 		v.LangProcConfigDir = base_reg.ReplaceAllString(v.LangProcConfigDir, cwd)
+		v.HistoryPath = base_reg.ReplaceAllString(v.HistoryPath, cwd)
+		v.BuildCachePath = base_reg.ReplaceAllString(v.BuildCachePath, cwd)
+		v.BlobStorePath = base_reg.ReplaceAllString(v.BlobStorePath, cwd)
 	}
 
 	//
@@ -88,6 +115,13 @@ func main() {
     log.Printf("Profiles:           %s\n", target_config.LangProcConfigDir)
     log.Printf("ProcZipAddress:     %s\n", target_config.LangProcUpdateZipAddress)
 	log.Printf("ProcPackageType:    %s\n", target_config.ProcPackageType)
+	log.Printf("HistoryPath:        %s\n", target_config.HistoryPath)
+	log.Printf("BuildCachePath:     %s\n", target_config.BuildCachePath)
+	log.Printf("UseResultCache:     %t\n", target_config.UseResultCache)
+	log.Printf("BlobStorePath:      %s\n", target_config.BlobStorePath)
+	log.Printf("RunParallelism:     %d\n", target_config.RunParallelism)
+	log.Printf("CgroupRootPath:     %s\n", target_config.CgroupRootPath)
+	log.Printf("MaxLimits:          %+v\n", target_config.MaxLimits)
 
 	var updater torigoya.PackageUpdater = nil
 	switch target_config.ProcPackageType {
@@ -112,6 +146,68 @@ func main() {
 		log.Panicf(err.Error())
 	}
 
+	if target_config.HistoryPath != "" {
+		store, err := torigoya.NewFileHistoryStore(
+			target_config.HistoryPath,
+			torigoya.HistoryRetention{
+				MaxAge: time.Duration(target_config.HistoryMaxAgeDays) * 24 * time.Hour,
+				MaxRecords: target_config.HistoryMaxRecords,
+			},
+		)
+		if err != nil {
+			log.Panicf("Error (%v)\n", err)
+		}
+		ctx.SetHistoryStore(store)
+	}
+
+	if target_config.BuildCachePath != "" {
+		cache, err := torigoya.NewBuildCache(
+			target_config.BuildCachePath,
+			torigoya.BuildCacheLimits{
+				MaxAge: time.Duration(target_config.BuildCacheMaxAgeDays) * 24 * time.Hour,
+				MaxBytes: target_config.BuildCacheMaxMegaBytes * 1024 * 1024,
+			},
+		)
+		if err != nil {
+			log.Panicf("Error (%v)\n", err)
+		}
+		ctx.SetBuildCache(cache)
+	}
+
+	if target_config.UseResultCache {
+		ctx.SetResultCache(torigoya.NewResultCache(
+			torigoya.ResultCacheLimits{
+				MaxAge: time.Duration(target_config.ResultCacheMaxAgeMinutes) * time.Minute,
+				MaxBytes: target_config.ResultCacheMaxMegaBytes * 1024 * 1024,
+				MaxEntries: target_config.ResultCacheMaxEntries,
+			},
+		))
+	}
+
+	if target_config.BlobStorePath != "" {
+		store, err := torigoya.NewBlobStore(
+			target_config.BlobStorePath,
+			torigoya.BlobStoreLimits{
+				MaxAge: time.Duration(target_config.BlobStoreMaxAgeDays) * 24 * time.Hour,
+				MaxBytes: target_config.BlobStoreMaxMegaBytes * 1024 * 1024,
+			},
+		)
+		if err != nil {
+			log.Panicf("Error (%v)\n", err)
+		}
+		ctx.SetBlobStore(store)
+	}
+
+	ctx.SetRunParallelism(target_config.RunParallelism)
+
+	if target_config.CgroupRootPath != "" {
+		if err := ctx.SetCgroupRoot(target_config.CgroupRootPath); err != nil {
+			log.Panicf("Error (%v)\n", err)
+		}
+	}
+
+	ctx.SetMaxLimits(&target_config.MaxLimits)
+
 	if !ctx.HasProcTable() {
 		log.Printf("Try to download/reload proc_table...\n")
 		if err := ctx.UpdateProcTable(); err != nil {
@@ -0,0 +1,153 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+package torigoya
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"bytes"
+	"strings"
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+)
+
+
+// limitations of all archives which are given as sources of a ticket
+const (
+	maxArchiveFiles			= 1024
+	maxArchiveTotalBytes	= 64 * 1024 * 1024
+)
+
+// supports tar, tar.gz and zip. only regular files and directories can be contained.
+// the extractor is shared by archives, so that limitations are applied to the total of them
+func extractArchive(extractor *archiveExtractor, data []byte) ([]*TextContent, error) {
+	// contents of this archive
+	offset := len(extractor.contents)
+	if err := extractArchiveTo(extractor, data); err != nil {
+		return nil, err
+	}
+	return extractor.contents[offset:], nil
+}
+
+func extractArchiveTo(extractor *archiveExtractor, data []byte) error {
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")), bytes.HasPrefix(data, []byte("PK\x05\x06")):
+		return extractZipArchive(extractor, data)
+
+	case bytes.HasPrefix(data, []byte("\x1f\x8b")):
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return errors.New("archive: invalid gzip data (" + err.Error() + ")")
+		}
+		defer r.Close()
+		return extractTarArchive(extractor, r)
+
+	default:
+		return extractTarArchive(extractor, bytes.NewReader(data))
+	}
+}
+
+func extractTarArchive(extractor *archiveExtractor, r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF { break }
+		if err != nil {
+			return errors.New("archive: invalid tar data (" + err.Error() + ")")
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			continue
+
+		case tar.TypeReg, tar.TypeRegA:
+			if err := extractor.add(header.Name, header.Size, tr); err != nil {
+				return err
+			}
+
+		default:
+			// symlinks, hardlinks, devices, etc...
+			return errors.New(fmt.Sprintf("archive: %s is not a regular file", header.Name))
+		}
+	}
+
+	return nil
+}
+
+func extractZipArchive(extractor *archiveExtractor, data []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return errors.New("archive: invalid zip data (" + err.Error() + ")")
+	}
+
+	for _, f := range zr.File {
+		mode := f.Mode()
+		if mode.IsDir() {
+			continue
+		}
+		if !mode.IsRegular() {
+			return errors.New(fmt.Sprintf("archive: %s is not a regular file", f.Name))
+		}
+
+		if err := func() error {
+			rc, err := f.Open()
+			if err != nil { return err }
+			defer rc.Close()
+
+			return extractor.add(f.Name, int64(f.UncompressedSize64), rc)
+		}(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+
+//
+type archiveExtractor struct {
+	contents		[]*TextContent
+	totalBytes		int64
+}
+
+// size is only a hint. the data is read up to the limitation
+func (e *archiveExtractor) add(name string, size int64, r io.Reader) error {
+	name = strings.TrimPrefix(name, "./")
+	if err := validateSourceName(name); err != nil {
+		return errors.New("archive: " + err.Error())
+	}
+
+	if len(e.contents) >= maxArchiveFiles {
+		return errors.New(fmt.Sprintf("archive: too many files (> %d)", maxArchiveFiles))
+	}
+
+	remaining := int64(maxArchiveTotalBytes) - e.totalBytes
+	if size > remaining {
+		return errors.New(fmt.Sprintf("archive: too large (> %d bytes)", maxArchiveTotalBytes))
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r, remaining + 1))
+	if err != nil {
+		return errors.New(fmt.Sprintf("archive: couldn't read %s (%s)", name, err))
+	}
+	if int64(len(data)) > remaining {
+		return errors.New(fmt.Sprintf("archive: too large (> %d bytes)", maxArchiveTotalBytes))
+	}
+
+	e.totalBytes += int64(len(data))
+	e.contents = append(e.contents, &TextContent{
+		Name: name,
+		Data: data,
+	})
+
+	return nil
+}
@@ -0,0 +1,144 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+package torigoya
+
+import (
+	"testing"
+	"bytes"
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+)
+
+
+type testArchiveEntry struct {
+	name		string
+	typeflag	byte
+	data		string
+}
+
+func makeTestTar(t *testing.T, entries []testArchiveEntry) []byte {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	for _, e := range entries {
+		header := &tar.Header{ Name: e.name, Typeflag: e.typeflag, Mode: 0644, Size: int64(len(e.data)) }
+		if e.typeflag != tar.TypeReg {
+			header.Size = 0
+			header.Linkname = e.data
+		}
+		if err := tw.WriteHeader(header); err != nil { t.Fatalf("%v", err) }
+		if e.typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.data)); err != nil { t.Fatalf("%v", err) }
+		}
+	}
+	if err := tw.Close(); err != nil { t.Fatalf("%v", err) }
+
+	return buf.Bytes()
+}
+
+func TestUnitExtractArchive(t *testing.T) {
+	entries := []testArchiveEntry{
+		{ "./src/", tar.TypeDir, "" },
+		{ "./src/main.rs", tar.TypeReg, "fn main() {}" },
+		{ "Cargo.toml", tar.TypeReg, "[package]" },
+	}
+
+	// tar
+	tar_data := makeTestTar(t, entries)
+
+	// tar.gz
+	gz_buf := bytes.NewBuffer(nil)
+	gw := gzip.NewWriter(gz_buf)
+	gw.Write(tar_data)
+	gw.Close()
+
+	// zip
+	zip_buf := bytes.NewBuffer(nil)
+	zw := zip.NewWriter(zip_buf)
+	for _, e := range entries[1:] {
+		w, err := zw.Create(e.name)
+		if err != nil { t.Fatalf("%v", err) }
+		w.Write([]byte(e.data))
+	}
+	zw.Close()
+
+	for _, data := range [][]byte{ tar_data, gz_buf.Bytes(), zip_buf.Bytes() } {
+		contents, err := extractArchive(&archiveExtractor{}, data)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if len(contents) != 2 {
+			t.Fatalf("2 files should be extracted (but %d)", len(contents))
+		}
+		if contents[0].Name != "src/main.rs" || string(contents[0].Data) != "fn main() {}" {
+			t.Fatalf("invalid content %s: %s", contents[0].Name, contents[0].Data)
+		}
+		if contents[1].Name != "Cargo.toml" || string(contents[1].Data) != "[package]" {
+			t.Fatalf("invalid content %s: %s", contents[1].Name, contents[1].Data)
+		}
+	}
+}
+
+func TestUnitExtractArchiveRejection(t *testing.T) {
+	cases := [][]testArchiveEntry{
+		{ { "../evil", tar.TypeReg, "x" } },
+		{ { "/etc/passwd", tar.TypeReg, "x" } },
+		{ { "link", tar.TypeSymlink, "/etc/passwd" } },
+		{ { "hard", tar.TypeLink, "main.cpp" } },
+		{ { "dev", tar.TypeChar, "" } },
+		{ { "a.txt", tar.TypeReg, "x" }, { "a/../../b.txt", tar.TypeReg, "x" } },
+	}
+
+	for i, entries := range cases {
+		if _, err := extractArchive(&archiveExtractor{}, makeTestTar(t, entries)); err == nil {
+			t.Fatalf("case %d: archive should be rejected", i)
+		}
+	}
+
+	// too many files
+	var many []testArchiveEntry
+	for i := 0; i <= maxArchiveFiles; i++ {
+		many = append(many, testArchiveEntry{ "f" + string(rune('a' + i % 26)) + string(rune('a' + i / 26 % 26)) + string(rune('a' + i / 676)), tar.TypeReg, "" })
+	}
+	if _, err := extractArchive(&archiveExtractor{}, makeTestTar(t, many)); err == nil {
+		t.Fatalf("archive which contains too many files should be rejected")
+	}
+}
+
+func TestUnitExtractArchivesInTotal(t *testing.T) {
+	make_entries := func(prefix string, n int) []testArchiveEntry {
+		var entries []testArchiveEntry
+		for i := 0; i < n; i++ {
+			entries = append(entries, testArchiveEntry{ prefix + "/" + string(rune('a' + i % 26)) + string(rune('a' + i / 26 % 26)), tar.TypeReg, "x" })
+		}
+		return entries
+	}
+
+	// each archive is within the limitation
+	sources := []*SourceData{
+		&SourceData{ Name: "a.tar", Data: makeTestTar(t, make_entries("a", 2)), IsArchive: true },
+		&SourceData{ Name: "main.cpp", Data: []byte("int main() {}") },
+		&SourceData{ Name: "b.tar", Data: makeTestTar(t, make_entries("b", 2)), IsArchive: true },
+	}
+	contents, err := convertSourcesToContents(sources)
+	if err != nil { t.Fatalf("%v", err) }
+	if len(contents) != 5 || contents[2].Name != "main.cpp" || contents[3].Name != "b/aa" {
+		t.Fatalf("files should be extracted in order (but %d)", len(contents))
+	}
+
+	// the total exceeds the limitation
+	sources = []*SourceData{
+		&SourceData{ Name: "a.tar", Data: makeTestTar(t, make_entries("a", maxArchiveFiles / 2 + 1)), IsArchive: true },
+		&SourceData{ Name: "b.tar", Data: makeTestTar(t, make_entries("b", maxArchiveFiles / 2 + 1)), IsArchive: true },
+	}
+	if _, err := convertSourcesToContents(sources[:1]); err != nil { t.Fatalf("%v", err) }
+	if _, err := convertSourcesToContents(sources); err == nil {
+		t.Fatalf("archives which contain too many files in total should be rejected")
+	}
+}
@@ -0,0 +1,170 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import(
+	"log"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"path/filepath"
+)
+
+
+// limitations of collected artifacts per execution
+const (
+	maxArtifactFileBytes	= 4 * 1024 * 1024
+	maxArtifactTotalBytes	= 16 * 1024 * 1024
+	maxArtifactFiles		= 64
+)
+
+//
+type Artifact struct {
+	Name			string		// relative path from HOME
+	Size			uint64
+	Data			[]byte		// nil if omitted
+	IsOmitted		bool		// the file exceeded the limitation
+}
+
+func (a *Artifact) ToTuple() []interface{} {
+	return []interface{}{ a.Name, a.Size, a.Data, a.IsOmitted }
+}
+
+//
+type StreamArtifacts struct {
+	Mode			int
+	Index			int
+	Artifacts		[]*Artifact
+}
+
+func (r *StreamArtifacts) ToTuple() []interface{} {
+	artifacts := make([]interface{}, len(r.Artifacts))
+	for i, a := range r.Artifacts {
+		artifacts[i] = a.ToTuple()
+	}
+
+	return []interface{}{ r.Mode, r.Index, artifacts }
+}
+
+
+//
+func (ctx *Context) collectArtifacts(
+	base_name			string,
+	patterns			[]string,
+	mode				int,
+	index				int,
+	callback			invokeResultRecieverCallback,
+) error {
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	user_home_path := filepath.Join(ctx.makeUserDirName(base_name), ctx.jailedUserDir)
+	artifacts, err := collectArtifactFiles(user_home_path, patterns)
+	if err != nil {
+		return err
+	}
+
+	if callback != nil {
+		callback(&StreamArtifacts{
+			Mode: mode,
+			Index: index,
+			Artifacts: artifacts,
+		})
+	}
+
+	return nil
+}
+
+// only regular files are collected. files under read only directories are ignored
+func collectArtifactFiles(
+	user_home_path		string,
+	patterns			[]string,
+) ([]*Artifact, error) {
+	artifacts := []*Artifact{}
+	var total_bytes uint64 = 0
+
+	err := filepath.Walk(user_home_path, func(path string, info os.FileInfo, err error) error {
+		if err != nil { return err }
+		if path == user_home_path { return nil }
+
+		rel_path, err := filepath.Rel(user_home_path, path)
+		if err != nil { return err }
+
+		if info.IsDir() {
+			if isReadOnlyDirName(rel_path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || !matchArtifactPatterns(patterns, rel_path) {
+			return nil
+		}
+		if len(artifacts) >= maxArtifactFiles {
+			log.Printf("collectArtifactFiles::too many artifacts, %s is ignored\n", rel_path)
+			return nil
+		}
+
+		//
+		artifact := &Artifact{
+			Name: rel_path,
+			Size: uint64(info.Size()),
+		}
+		artifacts = append(artifacts, artifact)
+
+		if artifact.Size > maxArtifactFileBytes || total_bytes + artifact.Size > maxArtifactTotalBytes {
+			artifact.IsOmitted = true
+			return nil
+		}
+
+		data, err := readArtifactFile(path, artifact.Size)
+		if err != nil {
+			log.Printf("collectArtifactFiles::couldn't read %s (%v)\n", path, err)
+			artifact.IsOmitted = true
+			return nil
+		}
+		artifact.Data = data
+		total_bytes += uint64(len(data))
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return artifacts, nil
+}
+
+func matchArtifactPatterns(patterns []string, rel_path string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, rel_path); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// the file may be replaced with a symlink by processes of the managed user
+func readArtifactFile(path string, size uint64) ([]byte, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil { return nil, err }
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil { return nil, err }
+	if !info.Mode().IsRegular() {
+		return nil, errors.New("not a regular file")
+	}
+
+	return ioutil.ReadAll(io.LimitReader(f, int64(size)))
+}
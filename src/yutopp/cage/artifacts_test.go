@@ -0,0 +1,53 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import (
+	"testing"
+	"os"
+	"io/ioutil"
+	"path/filepath"
+)
+
+
+func TestUnitCollectArtifactFiles(t *testing.T) {
+	home, err := ioutil.TempDir("", "torigoya_artifacts")
+	if err != nil { t.Fatalf("%v", err) }
+	defer os.RemoveAll(home)
+
+	os.MkdirAll(filepath.Join(home, "out"), 0700)
+	os.MkdirAll(filepath.Join(home, "stdin"), 0700)
+	ioutil.WriteFile(filepath.Join(home, "result.csv"), []byte("a,b\n"), 0600)
+	ioutil.WriteFile(filepath.Join(home, "out", "plot.png"), []byte("png"), 0600)
+	ioutil.WriteFile(filepath.Join(home, "stdin", "input.csv"), []byte("secret"), 0600)
+	ioutil.WriteFile(filepath.Join(home, "large.csv"), make([]byte, maxArtifactFileBytes + 1), 0600)
+	os.Symlink("/etc/passwd", filepath.Join(home, "link.csv"))
+
+	artifacts, err := collectArtifactFiles(home, []string{ "*.csv", "out/*" })
+	if err != nil { t.Fatalf("%v", err) }
+
+	found := map[string]*Artifact{}
+	for _, a := range artifacts {
+		found[a.Name] = a
+	}
+	if len(found) != 3 {
+		t.Fatalf("3 artifacts should be collected (but %v)", found)
+	}
+	if a, ok := found["result.csv"]; !ok || string(a.Data) != "a,b\n" {
+		t.Fatalf("result.csv should be collected")
+	}
+	if a, ok := found["out/plot.png"]; !ok || string(a.Data) != "png" {
+		t.Fatalf("out/plot.png should be collected")
+	}
+	if a, ok := found["large.csv"]; !ok || !a.IsOmitted || a.Data != nil {
+		t.Fatalf("large.csv should be omitted")
+	}
+}
@@ -0,0 +1,278 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+package torigoya
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+	"path/filepath"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+
+// ========================================
+// blobs are named by hex encoded SHA-256 of their contents
+func makeBlobHash(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func validateBlobHash(hash string) error {
+	if len(hash) != sha256.Size * 2 {
+		return errors.New(fmt.Sprintf("invalid blob hash (%s)", hash))
+	}
+	for _, r := range hash {
+		if !('0' <= r && r <= '9') && !('a' <= r && r <= 'f') {
+			return errors.New(fmt.Sprintf("invalid blob hash (%s)", hash))
+		}
+	}
+
+	return nil
+}
+
+
+// ========================================
+// a ticket which references blobs that are not in the store
+type MissingBlobsError struct {
+	Hashes				[]string
+}
+
+func (e *MissingBlobsError) Error() string {
+	return fmt.Sprintf("missing blobs (%s)", strings.Join(e.Hashes, ", "))
+}
+
+
+// ========================================
+// zero values mean "unlimited"
+type BlobStoreLimits struct {
+	MaxAge				time.Duration
+	MaxBytes			uint64
+}
+
+// blobs are kept as <path>/<first 2 chars of hash>/<hash>
+type BlobStore struct {
+	path				string
+	limits				BlobStoreLimits
+
+	mutex				sync.Mutex		// guards entries and files of them
+	entries				map[string]*cacheEntry
+}
+
+const blobTempPrefix = ".tmp-"
+
+func NewBlobStore(path string, limits BlobStoreLimits) (*BlobStore, error) {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, errors.New(fmt.Sprintf("Couldn't create directory for blobs %s (%s)", path, err))
+	}
+
+	store := &BlobStore{
+		path: path,
+		limits: limits,
+		entries: map[string]*cacheEntry{},
+	}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	if err := store.Prune(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// blobs which were stored by the previous process are reused
+func (s *BlobStore) load() error {
+	return filepath.Walk(s.path, func(path string, info os.FileInfo, err error) error {
+		if err != nil { return err }
+		if info.IsDir() { return nil }
+
+		// unfinished or unknown files
+		if strings.HasPrefix(info.Name(), blobTempPrefix) || validateBlobHash(info.Name()) != nil || path != s.makeBlobPath(info.Name()) {
+			log.Printf("BlobStore::remove %s\n", path)
+			return os.Remove(path)
+		}
+
+		s.entries[info.Name()] = &cacheEntry{
+			key: info.Name(),
+			bytes: uint64(info.Size()),
+			lastUsedAt: info.ModTime(),
+		}
+		return nil
+	})
+}
+
+func (s *BlobStore) makeBlobPath(hash string) string {
+	return filepath.Join(s.path, hash[:2], hash)
+}
+
+//
+func (s *BlobStore) Put(data []byte) (string, error) {
+	hash := makeBlobHash(data)
+	if s.limits.MaxBytes != 0 && uint64(len(data)) > s.limits.MaxBytes {
+		return "", errors.New(fmt.Sprintf("blob is too large (%d bytes)", len(data)))
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if entry, ok := s.entries[hash]; ok {
+		s.touchLocked(entry, now)
+		return hash, nil
+	}
+
+	// write to the temporary file, then rename it atomically
+	blob_path := s.makeBlobPath(hash)
+	if err := os.MkdirAll(filepath.Dir(blob_path), 0700); err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(blob_path), blobTempPrefix)
+	if err != nil { return "", err }
+	tmp_path := f.Name()
+	defer os.Remove(tmp_path)
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp_path, blob_path); err != nil {
+		return "", err
+	}
+
+	s.entries[hash] = &cacheEntry{
+		key: hash,
+		bytes: uint64(len(data)),
+		lastUsedAt: now,
+	}
+
+	return hash, s.pruneLocked()
+}
+
+//
+func (s *BlobStore) Get(hash string) ([]byte, bool, error) {
+	if err := validateBlobHash(hash); err != nil {
+		return nil, false, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, ok := s.entries[hash]
+	if !ok {
+		return nil, false, nil
+	}
+
+	data, err := ioutil.ReadFile(s.makeBlobPath(hash))
+	if err != nil {
+		if os.IsNotExist(err) {
+			delete(s.entries, hash)
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	s.touchLocked(entry, time.Now())
+
+	return data, true, nil
+}
+
+func (s *BlobStore) touchLocked(entry *cacheEntry, now time.Time) {
+	entry.lastUsedAt = now
+	if err := os.Chtimes(s.makeBlobPath(entry.key), now, now); err != nil {
+		log.Printf("BlobStore::couldn't touch %s (%v)\n", entry.key, err)
+	}
+}
+
+func (s *BlobStore) Prune() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.pruneLocked()
+}
+
+func (s *BlobStore) pruneLocked() error {
+	entries := make([]*cacheEntry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+
+	for _, e := range selectEvictedCacheEntries(entries, s.limits.MaxAge, s.limits.MaxBytes, time.Now()) {
+		log.Printf("BlobStore::evict %s (%d bytes)\n", e.key, e.bytes)
+		if err := os.Remove(s.makeBlobPath(e.key)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		delete(s.entries, e.key)
+	}
+
+	return nil
+}
+
+
+// ========================================
+// data of sources which reference blobs are filled from the store
+func resolveBlobReferences(store *BlobStore, sources []*SourceData) error {
+	missing := []string{}
+	is_missing := map[string]bool{}
+	for _, source := range sources {
+		if source == nil || source.BlobHash == "" {
+			continue
+		}
+
+		if store == nil {
+			return errors.New("Blob Store was not registerd")
+		}
+		data, ok, err := store.Get(source.BlobHash)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if !is_missing[source.BlobHash] {
+				missing = append(missing, source.BlobHash)
+				is_missing[source.BlobHash] = true
+			}
+			continue
+		}
+
+		source.Data = data
+	}
+
+	if len(missing) > 0 {
+		return &MissingBlobsError{ Hashes: missing }
+	}
+
+	return nil
+}
+
+// all sources in the ticket including inputs and judge programs
+func collectTicketSources(ticket *Ticket) []*SourceData {
+	sources := append([]*SourceData{}, ticket.Sources...)
+
+	if ticket.RunInst != nil {
+		for _, input := range ticket.RunInst.Inputs {
+			sources = append(sources, input.stdin, input.expected)
+			sources = append(sources, input.files...)
+		}
+	}
+	for _, program := range []*JudgeProgram{ ticket.Checker, ticket.Interactor } {
+		if program != nil {
+			sources = append(sources, program.Sources...)
+		}
+	}
+
+	return sources
+}
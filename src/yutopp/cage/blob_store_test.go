@@ -0,0 +1,89 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+package torigoya
+
+import (
+	"testing"
+	"os"
+	"io/ioutil"
+)
+
+
+func TestUnitBlobStore(t *testing.T) {
+	path, err := ioutil.TempDir("", "torigoya_blobs")
+	if err != nil { t.Fatalf("%v", err) }
+	defer os.RemoveAll(path)
+
+	store, err := NewBlobStore(path, BlobStoreLimits{ MaxBytes: 8 })
+	if err != nil { t.Fatalf("%v", err) }
+
+	hash_a, err := store.Put([]byte("aaaa"))
+	if err != nil { t.Fatalf("%v", err) }
+	if hash_a != makeBlobHash([]byte("aaaa")) {
+		t.Fatalf("blob should be named by the hash of it")
+	}
+	hash_b, err := store.Put([]byte("bbbb"))
+	if err != nil { t.Fatalf("%v", err) }
+
+	// "a" becomes the most recently used, so "b" is evicted
+	if data, ok, err := store.Get(hash_a); err != nil || !ok || string(data) != "aaaa" {
+		t.Fatalf("blob a should be stored (%v, %v)", ok, err)
+	}
+	if _, err := store.Put([]byte("cccc")); err != nil { t.Fatalf("%v", err) }
+	if _, ok, _ := store.Get(hash_b); ok {
+		t.Fatalf("blob b should be evicted")
+	}
+	if _, err := store.Put([]byte("too large")); err == nil {
+		t.Fatalf("blob which is larger than the limitation should be rejected")
+	}
+
+	// blobs are kept over restarts
+	reloaded, err := NewBlobStore(path, BlobStoreLimits{})
+	if err != nil { t.Fatalf("%v", err) }
+	if data, ok, err := reloaded.Get(hash_a); err != nil || !ok || string(data) != "aaaa" {
+		t.Fatalf("blob a should be reloaded (%v, %v)", ok, err)
+	}
+
+	// references
+	sources := []*SourceData{
+		&SourceData{ Name: "in", BlobHash: hash_a },
+		&SourceData{ Name: "in2", BlobHash: hash_b },
+		&SourceData{ Name: "in3", BlobHash: hash_b },
+		&SourceData{ Name: "inline", Data: []byte("x") },
+	}
+	err = resolveBlobReferences(reloaded, sources)
+	missing_err, ok := err.(*MissingBlobsError)
+	if !ok || len(missing_err.Hashes) != 1 || missing_err.Hashes[0] != hash_b {
+		t.Fatalf("blob b should be reported as missing (but %v)", err)
+	}
+	if string(sources[0].Data) != "aaaa" || string(sources[3].Data) != "x" {
+		t.Fatalf("references should be resolved")
+	}
+}
+
+func TestUnitSourceDataBlobReference(t *testing.T) {
+	hash := makeBlobHash([]byte("1 2"))
+
+	source, err := MakeSourceDataFromTuple([]interface{}{ []byte("in"), nil, false, false, []byte(hash) })
+	if err != nil { t.Fatalf("%v", err) }
+	if source.BlobHash != hash || source.Data != nil {
+		t.Fatalf("blob should be referenced (but %v)", source)
+	}
+
+	invalids := [][]interface{}{
+		[]interface{}{ []byte("in"), []byte("1 2"), false, false, []byte(hash) },
+		[]interface{}{ []byte("in"), nil, false, false, []byte("../../etc/passwd") },
+		[]interface{}{ []byte("in"), nil, false, false },
+	}
+	for i, tupled := range invalids {
+		if _, err := MakeSourceDataFromTuple(tupled); err == nil {
+			t.Fatalf("case %d: source should be rejected", i)
+		}
+	}
+}
@@ -13,6 +13,7 @@ package torigoya
 import(
 	"log"
 	"errors"
+	"syscall"
 
 	"encoding/base64"
 	"github.com/ugorji/go/codec"
@@ -31,14 +32,31 @@ func (bp *BridgePipes) Close() {
 }
 
 
+// pipes which are connected to stdin/stdout of the process instead of the default ones
+type RedirectFds struct {
+	Stdin, Stdout		int
+	closed				bool
+}
+
+func (r *RedirectFds) close() {
+	if r.closed { return }
+	syscall.Close(r.Stdin)
+	syscall.Close(r.Stdout)
+	r.closed = true
+}
+
+
 //
 type BridgeMessage struct {
 	ChrootPath			string
 	JailedUserHomePath	string
 	JailedUser			*JailedUserInfo
 	Pipes				*BridgePipes
+	Redirect			*RedirectFds	// optional
 	Message				ExecMessage
 	IsReboot			bool
+	CgroupRootPath		string			// optional, required if the profile uses cgroup
+	MaxLimits			*PhaseLimits	// optional, limits are clamped to this
 }
 
 func (bm *BridgeMessage) Encode() (string, error) {
@@ -103,32 +121,14 @@ func (bm *BridgeMessage) compile() (*ExecutedResult, error) {
 	log.Println(">> called BridgeMessage::compile")
 	exec_message := bm.Message
 
-	proc_profile := exec_message.Profile
 	var stdin_file_path *string = nil	// ignore stdin
-	exec_setting := exec_message.Setting
-
-	// arguments
-	args, err := proc_profile.Compile.MakeCompleteArgs(
-		exec_setting.CommandLine,
-		exec_setting.StructuredCommand,
-	)
+	plan, err := makeExecPlan(exec_message.Profile, CompileMode, exec_message.Setting, bm.MaxLimits)
 	if err != nil {
 		return nil, err
 	}
 
-	//
-	res_limit := &ResourceLimit{
-		CPU: exec_setting.CpuTimeLimit,		// CPU limit(sec)
-		AS: exec_setting.MemoryBytesLimit,	// Memory limit(bytes)
-		FSize: 5 * 1024 * 1024,				// Process can writes a file only 5MiB
-	}
-
-	//
-	env := proc_profile.Compile.Env
-	umask := 0077	// rwx --- ---
-
 	// execute!
-	return bm.managedExec(res_limit, args, env, umask, stdin_file_path)
+	return bm.managedExec(plan.limit, plan.args, plan.env, plan.umask, stdin_file_path)
 }
 
 
@@ -138,32 +138,14 @@ func (bm *BridgeMessage) link() (*ExecutedResult, error) {
 
 	exec_message := bm.Message
 
-	proc_profile := exec_message.Profile
 	var stdin_file_path *string = nil	// ignore stdin
-	exec_setting := exec_message.Setting
-
-	// arguments
-	args, err := proc_profile.Link.MakeCompleteArgs(
-		exec_setting.CommandLine,
-		exec_setting.StructuredCommand,
-	)
+	plan, err := makeExecPlan(exec_message.Profile, LinkMode, exec_message.Setting, bm.MaxLimits)
 	if err != nil {
 		return nil, err
 	}
 
-	//
-	res_limit := &ResourceLimit{
-		CPU: 10,							// CPU limit(sec): 10sec[fixed]
-		AS: 2 * 1024 * 1024 * 1024,			// Memory limit(bytes): 2GiB[fixed]
-		FSize: 40 * 1024 * 1024,			// Process can writes a file only 40MiB[fixed]
-	}
-
-	//
-	env := proc_profile.Link.Env
-	umask := 0077	// rwx --- ---
-
 	// execute!
-	return bm.managedExec(res_limit, args, env, umask, stdin_file_path)
+	return bm.managedExec(plan.limit, plan.args, plan.env, plan.umask, stdin_file_path)
 }
 
 
@@ -172,30 +154,94 @@ func (bm *BridgeMessage) run() (*ExecutedResult, error) {
 	log.Println(">> called BridgeMessage::run")
 	exec_message := bm.Message
 
-	proc_profile := exec_message.Profile
 	stdin_file_path := exec_message.StdinFilePath
-	exec_setting := exec_message.Setting
-
-	// arguments
-	args, err := proc_profile.Run.MakeCompleteArgs(
-		exec_setting.CommandLine,
-		exec_setting.StructuredCommand,
-	)
+	plan, err := makeExecPlan(exec_message.Profile, RunMode, exec_message.Setting, bm.MaxLimits)
 	if err != nil {
 		return nil, err
 	}
 
-	//
-	res_limit := &ResourceLimit{
-		CPU: exec_setting.CpuTimeLimit,		// CPU limit(sec)
-		AS: exec_setting.MemoryBytesLimit,	// Memory limit(bytes)
-		FSize: 512 * 1024,				// Process can writes a file only 512KiB
+	// execute!
+	return bm.managedExec(plan.limit, plan.args, plan.env, plan.umask, stdin_file_path)
+}
+
+
+// everything to exec the phase. tickets are validated by this without execution
+type execPlan struct {
+	args				[]string
+	env					map[string]string
+	limit				*ResourceLimit
+	umask				int
+}
+
+func makeExecPlan(
+	proc_profile		*ProcProfile,
+	mode				int,
+	exec_setting		*ExecutionSetting,
+	max_limits			*PhaseLimits,
+) (*execPlan, error) {
+	if exec_setting == nil {
+		return nil, errors.New("makeExecPlan:: setting is nil")
 	}
 
-	//
-	env := proc_profile.Run.Env
-	umask := 0277	// r-x --- ---
+	switch mode {
+	case CompileMode:
+		// arguments
+		args, err := proc_profile.Compile.MakeCompleteArgs(
+			exec_setting.CommandLine,
+			exec_setting.StructuredCommand,
+		)
+		if err != nil {
+			return nil, err
+		}
 
-	// execute!
-	return bm.managedExec(res_limit, args, env, umask, stdin_file_path)
+		limit := makeResourceLimit(CompileMode, proc_profile.Compile.Limits, exec_setting, max_limits)
+
+		return &execPlan{
+			args: args,
+			env: makeStackSizeEnv(proc_profile.Compile.Env, proc_profile.Compile.StackSizeEnv, limit.Stack),
+			limit: limit,
+			umask: 0077,	// rwx --- ---
+		}, nil
+
+	case LinkMode:
+		// arguments
+		args, err := proc_profile.Link.MakeCompleteArgs(
+			exec_setting.CommandLine,
+			exec_setting.StructuredCommand,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		limit := makeResourceLimit(LinkMode, proc_profile.Link.Limits, exec_setting, max_limits)
+
+		return &execPlan{
+			args: args,
+			env: makeStackSizeEnv(proc_profile.Link.Env, proc_profile.Link.StackSizeEnv, limit.Stack),
+			limit: limit,
+			umask: 0077,	// rwx --- ---
+		}, nil
+
+	case RunMode:
+		// arguments
+		args, err := proc_profile.Run.MakeCompleteArgs(
+			exec_setting.CommandLine,
+			exec_setting.StructuredCommand,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		limit := makeResourceLimit(RunMode, proc_profile.Run.Limits, exec_setting, max_limits)
+
+		return &execPlan{
+			args: args,
+			env: makeStackSizeEnv(proc_profile.Run.Env, proc_profile.Run.StackSizeEnv, limit.Stack),
+			limit: limit,
+			umask: 0277,	// r-x --- ---
+		}, nil
+
+	default:
+		return nil, errors.New("makeExecPlan:: Invalid mode")
+	}
 }
@@ -0,0 +1,269 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import(
+	"log"
+	"errors"
+	"fmt"
+	"os"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"path/filepath"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+
+// ========================================
+// zero values mean "unlimited"
+type BuildCacheLimits struct {
+	MaxAge				time.Duration
+	MaxBytes			uint64
+}
+
+func (l *BuildCacheLimits) selectEvicted(entries []*cacheEntry, now time.Time) []*cacheEntry {
+	return selectEvictedCacheEntries(entries, l.MaxAge, l.MaxBytes, now)
+}
+
+const (
+	buildCacheHomeDirName	= "home"
+	buildCacheFramesName	= "frames"
+	buildCacheTempPrefix	= ".tmp-"
+)
+
+
+// ========================================
+// HOME directories after successful builds are kept as <path>/<key>/home
+type BuildCache struct {
+	path				string
+	limits				BuildCacheLimits
+
+	mutex				sync.Mutex		// guards entries and directories of them
+	entries				map[string]*cacheEntry
+}
+
+func NewBuildCache(path string, limits BuildCacheLimits) (*BuildCache, error) {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, errors.New(fmt.Sprintf("Couldn't create directory for build cache %s (%s)", path, err))
+	}
+
+	cache := &BuildCache{
+		path: path,
+		limits: limits,
+		entries: map[string]*cacheEntry{},
+	}
+	if err := cache.load(); err != nil {
+		return nil, err
+	}
+	if err := cache.Prune(); err != nil {
+		return nil, err
+	}
+
+	return cache, nil
+}
+
+// entries which were made by the previous process are reused
+func (c *BuildCache) load() error {
+	infos, err := ioutil.ReadDir(c.path)
+	if err != nil { return err }
+
+	for _, info := range infos {
+		entry_path := filepath.Join(c.path, info.Name())
+
+		// unfinished entries
+		if strings.HasPrefix(info.Name(), buildCacheTempPrefix) || !info.IsDir() || !fileExists(filepath.Join(entry_path, buildCacheFramesName)) {
+			if err := os.RemoveAll(entry_path); err != nil { return err }
+			continue
+		}
+
+		bytes, err := measureTreeBytes(entry_path)
+		if err != nil { return err }
+
+		c.entries[info.Name()] = &cacheEntry{
+			key: info.Name(),
+			bytes: bytes,
+			lastUsedAt: info.ModTime(),
+		}
+	}
+
+	return nil
+}
+
+func (c *BuildCache) Prune() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.pruneLocked()
+}
+
+func (c *BuildCache) pruneLocked() error {
+	entries := make([]*cacheEntry, 0, len(c.entries))
+	for _, e := range c.entries {
+		entries = append(entries, e)
+	}
+
+	for _, e := range c.limits.selectEvicted(entries, time.Now()) {
+		log.Printf("BuildCache::evict %s (%d bytes)\n", e.key, e.bytes)
+		if err := os.RemoveAll(filepath.Join(c.path, e.key)); err != nil {
+			return err
+		}
+		delete(c.entries, e.key)
+	}
+
+	return nil
+}
+
+func (c *BuildCache) Clear() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key, _ := range c.entries {
+		if err := os.RemoveAll(filepath.Join(c.path, key)); err != nil {
+			return err
+		}
+		delete(c.entries, key)
+	}
+
+	return nil
+}
+
+// makes the sandbox directory of the base_name from the cache, and returns recorded results
+func (c *BuildCache) restore(ctx *Context, key string, base_name string) ([]*cachedFrame, bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry_path := filepath.Join(c.path, key)
+
+	frames_bytes, err := ioutil.ReadFile(filepath.Join(entry_path, buildCacheFramesName))
+	if err != nil { return nil, false, err }
+
+	frames, err := decodeCachedFrames(frames_bytes)
+	if err != nil { return nil, false, err }
+
+	if err := ctx.makeWorkspaceFromHome(filepath.Join(entry_path, buildCacheHomeDirName), base_name); err != nil {
+		return nil, false, err
+	}
+
+	//
+	now := time.Now()
+	entry.lastUsedAt = now
+	if err := os.Chtimes(entry_path, now, now); err != nil {
+		log.Printf("BuildCache::couldn't touch %s (%v)\n", entry_path, err)
+	}
+
+	return frames, true, nil
+}
+
+// copies HOME of the base_name into the cache
+func (c *BuildCache) store(ctx *Context, key string, base_name string, frames []*cachedFrame) error {
+	// In posix, Uid only contains numbers
+	host_user_id, _ := strconv.Atoi(ctx.hostUser.Uid)
+
+	//
+	tmp_path, err := ioutil.TempDir(c.path, buildCacheTempPrefix)
+	if err != nil { return err }
+	defer os.RemoveAll(tmp_path)
+
+	user_home_path := filepath.Join(ctx.makeUserDirName(base_name), ctx.jailedUserDir)
+	if err := copyHomeTree(user_home_path, filepath.Join(tmp_path, buildCacheHomeDirName), host_user_id); err != nil {
+		return err
+	}
+
+	frames_bytes, err := encodeCachedFrames(frames)
+	if err != nil { return err }
+	if err := ioutil.WriteFile(filepath.Join(tmp_path, buildCacheFramesName), frames_bytes, 0600); err != nil {
+		return err
+	}
+
+	bytes, err := measureTreeBytes(tmp_path)
+	if err != nil { return err }
+	if c.limits.MaxBytes != 0 && bytes > c.limits.MaxBytes {
+		log.Printf("BuildCache::%s is too large to be cached (%d bytes)\n", key, bytes)
+		return nil
+	}
+
+	//
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// the same build may have been finished at the same time
+	if _, ok := c.entries[key]; ok {
+		return nil
+	}
+	if err := os.Rename(tmp_path, filepath.Join(c.path, key)); err != nil {
+		return err
+	}
+	c.entries[key] = &cacheEntry{
+		key: key,
+		bytes: bytes,
+		lastUsedAt: time.Now(),
+	}
+
+	return c.pruneLocked()
+}
+
+func measureTreeBytes(path string) (uint64, error) {
+	var bytes uint64 = 0
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil { return err }
+		if info.Mode().IsRegular() {
+			bytes += uint64(info.Size())
+		}
+		return nil
+	})
+
+	return bytes, err
+}
+
+
+// ========================================
+// the key is changed if anything which may affect outputs of the build is changed
+func makeBuildCacheKey(
+	proc_id				uint64,
+	proc_version		string,
+	proc_profile		*ProcProfile,
+	sources				[]*SourceData,
+	build_inst			*BuildInstruction,
+) (string, error) {
+	profile_bytes, err := json.Marshal(proc_profile)
+	if err != nil { return "", err }
+
+	build_inst_bytes, err := json.Marshal(build_inst)
+	if err != nil { return "", err }
+
+	h := sha256.New()
+	fmt.Fprintf(h, "build-cache-v1\n%d\n%q\n", proc_id, proc_version)
+	fmt.Fprintf(h, "%d:%s\n", len(profile_bytes), profile_bytes)
+	fmt.Fprintf(h, "%d:%s\n", len(build_inst_bytes), build_inst_bytes)
+	for _, source := range sources {
+		if source == nil { return "", errors.New("source is nil") }
+		fmt.Fprintf(h, "%s\n", digestSource(source))
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// data of the source is represented by the hash
+func digestSource(source *SourceData) string {
+	if source == nil { return "" }
+
+	source_hash := sha256.Sum256(source.Data)
+	return fmt.Sprintf("%q %t %t %s", source.Name, source.IsCompressed, source.IsArchive, hex.EncodeToString(source_hash[:]))
+}
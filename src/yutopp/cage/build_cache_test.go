@@ -0,0 +1,92 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import (
+	"testing"
+	"time"
+)
+
+
+func TestUnitBuildCacheKey(t *testing.T) {
+	profile := &ProcProfile{ Version: "test", IsBuildRequired: true }
+	sources := []*SourceData{ &SourceData{ Name: "prog.cpp", Data: []byte("int main() {}") } }
+	build_inst := &BuildInstruction{
+		CompileSetting: &ExecutionSetting{ CpuTimeLimit: 10, MemoryBytesLimit: 1024 },
+		LinkSetting: &ExecutionSetting{ CpuTimeLimit: 10, MemoryBytesLimit: 1024 },
+	}
+
+	base, err := makeBuildCacheKey(0, "test", profile, sources, build_inst)
+	if err != nil { t.Fatalf("%v", err) }
+
+	same, err := makeBuildCacheKey(0, "test", profile, []*SourceData{ &SourceData{ Name: "prog.cpp", Data: []byte("int main() {}") } }, build_inst)
+	if err != nil { t.Fatalf("%v", err) }
+	if base != same {
+		t.Fatalf("keys of the same build should be equal")
+	}
+
+	// anything which may affect outputs changes the key
+	cases := map[string]func() (string, error){
+		"proc_id": func() (string, error) { return makeBuildCacheKey(1, "test", profile, sources, build_inst) },
+		"version": func() (string, error) { return makeBuildCacheKey(0, "test2", profile, sources, build_inst) },
+		"profile": func() (string, error) {
+			p := *profile
+			p.IsLinkIndependent = true
+			return makeBuildCacheKey(0, "test", &p, sources, build_inst)
+		},
+		"source": func() (string, error) {
+			return makeBuildCacheKey(0, "test", profile, []*SourceData{ &SourceData{ Name: "prog.cpp", Data: []byte("int main() { }") } }, build_inst)
+		},
+		"source name": func() (string, error) {
+			return makeBuildCacheKey(0, "test", profile, []*SourceData{ &SourceData{ Name: "main.cpp", Data: []byte("int main() {}") } }, build_inst)
+		},
+		"setting": func() (string, error) {
+			inst := *build_inst
+			inst.CompileSetting = &ExecutionSetting{ CpuTimeLimit: 10, MemoryBytesLimit: 1024, StructuredCommand: [][]string{ []string{ "-O2" } } }
+			return makeBuildCacheKey(0, "test", profile, sources, &inst)
+		},
+	}
+
+	for name, make_key := range cases {
+		key, err := make_key()
+		if err != nil { t.Fatalf("%s: %v", name, err) }
+		if key == base {
+			t.Fatalf("key should be changed by %s", name)
+		}
+	}
+}
+
+func TestUnitBuildCacheEviction(t *testing.T) {
+	now := time.Now()
+	entries := []*cacheEntry{
+		&cacheEntry{ key: "new", bytes: 100, lastUsedAt: now },
+		&cacheEntry{ key: "old", bytes: 100, lastUsedAt: now.Add(-2 * time.Hour) },
+		&cacheEntry{ key: "middle", bytes: 100, lastUsedAt: now.Add(-30 * time.Minute) },
+	}
+
+	evicted_keys := func(limits BuildCacheLimits) []string {
+		keys := []string{}
+		for _, e := range limits.selectEvicted(entries, now) {
+			keys = append(keys, e.key)
+		}
+		return keys
+	}
+
+	if keys := evicted_keys(BuildCacheLimits{}); len(keys) != 0 {
+		t.Fatalf("nothing should be evicted without limits (but %v)", keys)
+	}
+	if keys := evicted_keys(BuildCacheLimits{ MaxAge: time.Hour }); len(keys) != 1 || keys[0] != "old" {
+		t.Fatalf("expired entry should be evicted (but %v)", keys)
+	}
+	if keys := evicted_keys(BuildCacheLimits{ MaxBytes: 150 }); len(keys) != 2 || keys[0] != "old" || keys[1] != "middle" {
+		t.Fatalf("least recently used entries should be evicted (but %v)", keys)
+	}
+}
@@ -0,0 +1,59 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+package torigoya
+
+import (
+	"sort"
+	"time"
+)
+
+
+// an entry of caches which are stored in the local file system
+type cacheEntry struct {
+	key					string
+	bytes				uint64
+	lastUsedAt			time.Time
+}
+
+type cacheEntriesByLastUsed []*cacheEntry
+
+func (c cacheEntriesByLastUsed) Len() int { return len(c) }
+func (c cacheEntriesByLastUsed) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c cacheEntriesByLastUsed) Less(i, j int) bool { return c[i].lastUsedAt.Before(c[j].lastUsedAt) }
+
+// entries which should be evicted. least recently used entries are evicted first
+// zero values of limitations mean "unlimited"
+func selectEvictedCacheEntries(
+	entries				[]*cacheEntry,
+	max_age				time.Duration,
+	max_bytes			uint64,
+	now					time.Time,
+) []*cacheEntry {
+	sorted := append([]*cacheEntry{}, entries...)
+	sort.Sort(cacheEntriesByLastUsed(sorted))
+
+	evicted := []*cacheEntry{}
+	var total_bytes uint64 = 0
+	for _, e := range sorted {
+		total_bytes += e.bytes
+	}
+
+	for _, e := range sorted {
+		is_expired := max_age != 0 && now.Sub(e.lastUsedAt) > max_age
+		is_overflowed := max_bytes != 0 && total_bytes > max_bytes
+		if !is_expired && !is_overflowed {
+			continue
+		}
+
+		evicted = append(evicted, e)
+		total_bytes -= e.bytes
+	}
+
+	return evicted
+}
@@ -0,0 +1,241 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import(
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+
+// ProcProfile.ResourceControl
+const (
+	ResourceControlRlimit	= "rlimit"		// default
+	ResourceControlCgroup	= "cgroup"
+)
+
+func isCgroupControlled(proc_profile *ProcProfile) bool {
+	return proc_profile != nil && proc_profile.ResourceControl == ResourceControlCgroup
+}
+
+// controllers which are enabled for cgroups of executions
+var cgroupControllers = []string{ "cpu", "memory", "pids" }
+
+// only 1 CPU can be used by processes in the cgroup (quota and period in microseconds)
+const cgroupCpuMax = "100000 100000"
+
+
+// root_path must be a directory of cgroup v2 hierarchy (e.g. /sys/fs/cgroup/torigoya)
+func prepareCgroupRoot(root_path string) error {
+	if err := os.MkdirAll(root_path, 0755); err != nil {
+		return errors.New(fmt.Sprintf("Couldn't create cgroup %s (%s)", root_path, err))
+	}
+
+	controllers, err := ioutil.ReadFile(filepath.Join(root_path, "cgroup.controllers"))
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s is not a cgroup v2 directory (%s)", root_path, err))
+	}
+	available := strings.Fields(string(controllers))
+
+	var enabled []string
+	for _, c := range cgroupControllers {
+		found := false
+		for _, a := range available {
+			if a == c { found = true }
+		}
+		if !found {
+			return errors.New(fmt.Sprintf("cgroup controller %s is not available in %s", c, root_path))
+		}
+		enabled = append(enabled, "+" + c)
+	}
+
+	return ioutil.WriteFile(filepath.Join(root_path, "cgroup.subtree_control"), []byte(strings.Join(enabled, " ")), 0644)
+}
+
+// profiles which use cgroup can be executed only if the root of cgroups is set
+func (ctx *Context) checkResourceControl(proc_profile *ProcProfile) error {
+	switch proc_profile.ResourceControl {
+	case "", ResourceControlRlimit:
+		return nil
+	case ResourceControlCgroup:
+		if ctx.cgroupRootPath == "" {
+			return errors.New("cgroup resource control was requested, but cgroup root was not set")
+		}
+		return nil
+	default:
+		return errors.New(fmt.Sprintf("unknown resource control (%s)", proc_profile.ResourceControl))
+	}
+}
+
+
+// ========================================
+// a cgroup which is created for each execution
+type execCgroup struct {
+	path				string
+}
+
+func newExecCgroup(root_path string, rl *ResourceLimit) (*execCgroup, error) {
+	if root_path == "" {
+		return nil, errors.New("cgroup root was not set")
+	}
+
+	path := filepath.Join(root_path, fmt.Sprintf("exec-%d-%d", os.Getpid(), time.Now().UnixNano()))
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, errors.New(fmt.Sprintf("Couldn't create cgroup %s (%s)", path, err))
+	}
+	cg := &execCgroup{ path: path }
+
+	//
+	settings := []struct{ name, value string }{
+		{ "memory.max", strconv.FormatUint(rl.AS, 10) },
+		{ "pids.max", strconv.FormatUint(rl.NProc, 10) },		// same as RLIMIT_NPROC
+		{ "cpu.max", cgroupCpuMax },
+	}
+	for _, s := range settings {
+		if err := cg.write(s.name, s.value); err != nil {
+			cg.remove()
+			return nil, err
+		}
+	}
+	// swap is not always accounted
+	if err := cg.write("memory.swap.max", "0"); err != nil {
+		log.Printf("cgroup::couldn't disable swap (%v)\n", err)
+	}
+
+	return cg, nil
+}
+
+func (cg *execCgroup) write(name string, value string) error {
+	if err := ioutil.WriteFile(filepath.Join(cg.path, name), []byte(value), 0644); err != nil {
+		return errors.New(fmt.Sprintf("Couldn't write %s to %s (%s)", value, name, err))
+	}
+	return nil
+}
+
+func (cg *execCgroup) read(name string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(cg.path, name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// called by the child process before exec
+func (cg *execCgroup) enter() error {
+	return cg.write("cgroup.procs", strconv.Itoa(os.Getpid()))
+}
+
+// CPU time of all processes in the cgroup
+func (cg *execCgroup) cpuTime() (time.Duration, error) {
+	stat, err := cg.read("cpu.stat")
+	if err != nil {
+		return 0, err
+	}
+
+	usec, err := readCgroupKeyedValue(stat, "usage_usec")
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(usec) * time.Microsecond, nil
+}
+
+// user and system CPU time of all processes in the cgroup
+func (cg *execCgroup) cpuUserSystemTime() (time.Duration, time.Duration, error) {
+	stat, err := cg.read("cpu.stat")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	user_usec, err := readCgroupKeyedValue(stat, "user_usec")
+	if err != nil {
+		return 0, 0, err
+	}
+	system_usec, err := readCgroupKeyedValue(stat, "system_usec")
+	if err != nil {
+		return 0, 0, err
+	}
+	return time.Duration(user_usec) * time.Microsecond, time.Duration(system_usec) * time.Microsecond, nil
+}
+
+// the number of processes and threads in the cgroup
+func (cg *execCgroup) processCount() (uint64, uint64, error) {
+	procs, err := cg.read("cgroup.procs")
+	if err != nil {
+		return 0, 0, err
+	}
+	threads, err := cg.read("cgroup.threads")
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint64(len(strings.Fields(procs))), uint64(len(strings.Fields(threads))), nil
+}
+
+// peak memory usage of the cgroup. memory.peak is not supported by old kernels
+func (cg *execCgroup) peakMemoryBytes() (uint64, bool) {
+	peak, err := cg.read("memory.peak")
+	if err != nil {
+		return 0, false
+	}
+
+	bytes, err := strconv.ParseUint(peak, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return bytes, true
+}
+
+// counts of "oom_kill" and "max" in memory.events
+func (cg *execCgroup) memoryEvents() (oom_kill uint64, max uint64) {
+	events, err := cg.read("memory.events")
+	if err != nil {
+		return 0, 0
+	}
+
+	oom_kill, _ = readCgroupKeyedValue(events, "oom_kill")
+	max, _ = readCgroupKeyedValue(events, "max")
+	return oom_kill, max
+}
+
+// remaining processes (e.g. daemons which were made by the program) are killed
+func (cg *execCgroup) remove() {
+	if err := cg.write("cgroup.kill", "1"); err != nil {
+		log.Printf("cgroup::couldn't kill processes in %s (%v)\n", cg.path, err)
+	}
+
+	// the cgroup can be removed after all processes have exited
+	for i:=0; i<50; i++ {
+		if err := os.Remove(cg.path); err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	log.Printf("cgroup::couldn't remove %s\n", cg.path)
+}
+
+
+// e.g. "usage_usec 1234\nuser_usec 1000\n"
+func readCgroupKeyedValue(content string, key string) (uint64, error) {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+
+	return 0, errors.New(fmt.Sprintf("key %s was not found", key))
+}
@@ -0,0 +1,49 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import (
+	"testing"
+)
+
+
+func TestUnitReadCgroupKeyedValue(t *testing.T) {
+	stat := "usage_usec 1500\nuser_usec 1000\nsystem_usec 500\n"
+
+	v, err := readCgroupKeyedValue(stat, "usage_usec")
+	if err != nil { t.Fatalf("%v", err) }
+	if v != 1500 {
+		t.Fatalf("usage_usec should be 1500 (but %d)", v)
+	}
+
+	if _, err := readCgroupKeyedValue(stat, "usage"); err == nil {
+		t.Fatalf("unknown key should be rejected")
+	}
+}
+
+func TestUnitCheckResourceControl(t *testing.T) {
+	ctx := &Context{}
+
+	if err := ctx.checkResourceControl(&ProcProfile{}); err != nil {
+		t.Fatalf("rlimit should be used by default (%v)", err)
+	}
+	if err := ctx.checkResourceControl(&ProcProfile{ ResourceControl: ResourceControlCgroup }); err == nil {
+		t.Fatalf("cgroup should be rejected if the root was not set")
+	}
+	if err := ctx.checkResourceControl(&ProcProfile{ ResourceControl: "unknown" }); err == nil {
+		t.Fatalf("unknown resource control should be rejected")
+	}
+
+	ctx.cgroupRootPath = "/sys/fs/cgroup/torigoya"
+	if err := ctx.checkResourceControl(&ProcProfile{ ResourceControl: ResourceControlCgroup }); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
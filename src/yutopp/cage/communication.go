@@ -221,6 +221,18 @@ func acceptRequestMessage(
 		// send ProcProfiles to the client
 		acceptGetProcTableMessage(c, context, handler, error_event)
 
+	case MessageKindGetHistoryRequest:
+		// send execution histories to the client
+		acceptGetHistoryMessage(data, c, context, handler, error_event)
+
+	case MessageKindUploadBlobRequest:
+		// store blobs which are referenced by tickets
+		acceptUploadBlobRequest(data, c, context, handler, error_event)
+
+	case MessageKindValidateTicketRequest:
+		// resolve the ticket without execution
+		acceptValidateTicketRequest(data, c, context, handler, error_event)
+
 	default:
 		error_event <- errors.New(fmt.Sprintf("Server can not accept message (%d)", kind))
 		return
@@ -273,6 +285,28 @@ func acceptTicketRequestMessage(
 			error_happend = true
 			return
 
+		case *StreamScoreSummary:
+			var err error = nil
+			for i:=0; i<5; i++ {		// retry 5times if failed...
+				if err = handler.writeScoreSummary(c, v.(*StreamScoreSummary)); err == nil {
+					return
+				}
+			}
+			error_event <- errors.New("Failed to send score summary : " + err.Error())
+			error_happend = true
+			return
+
+		case *StreamArtifacts:
+			var err error = nil
+			for i:=0; i<5; i++ {		// retry 5times if failed...
+				if err = handler.writeArtifacts(c, v.(*StreamArtifacts)); err == nil {
+					return
+				}
+			}
+			error_event <- errors.New("Failed to send artifacts : " + err.Error())
+			error_happend = true
+			return
+
 		default:
 			error_event <- errors.New("Unsupported type object was given to callback")
 			error_happend = true
@@ -282,6 +316,17 @@ func acceptTicketRequestMessage(
 
 	// execute ticket data
 	if err := context.ExecTicket(ticket, f); err != nil {
+		// the client should upload missing blobs, then retry
+		if missing_err, ok := err.(*MissingBlobsError); ok {
+			for i:=0; i<5; i++ {		// retry 5times if failed...
+				if err = handler.writeMissingBlobs(c, missing_err.Hashes); err == nil {
+					return
+				}
+			}
+			error_event <- errors.New("Failed to send missing blobs : " + err.Error())
+			return
+		}
+
 		fmt.Printf("Server::Failed to exec ticket (%s)\n", err.Error())
 		error_event <- errors.New(fmt.Sprintf("Failed to exec ticket (%s)", err.Error()))
 		return
@@ -372,6 +417,103 @@ func acceptGetProcTableMessage(
 }
 
 
+//
+func acceptGetHistoryMessage(
+	data interface{},
+	c net.Conn,
+	context *Context,
+	handler *ProtocolHandler,
+	error_event chan<-error,
+) {
+	query, err := MakeHistoryQueryFromTuple(data)
+	if err != nil {
+		error_event <- errors.New(fmt.Sprintf("Invalid request (%s)", err.Error()))
+		return
+	}
+
+	records, err := context.QueryHistory(query)
+	if err != nil {
+		error_event <- err
+		return
+	}
+
+	for i:=0; i<5; i++ {		// retry 5times if failed...
+		if err = handler.writeHistory(c, records); err == nil {
+			return
+		}
+	}
+
+	error_event <- errors.New("Failed to send history: " + err.Error())
+}
+
+
+// blobs are given as a list of binaries
+func acceptUploadBlobRequest(
+	data interface{},
+	c net.Conn,
+	context *Context,
+	handler *ProtocolHandler,
+	error_event chan<-error,
+) {
+	blobs_array, ok := data.([]interface{})
+	if !ok {
+		error_event <- errors.New("Invalid request (blobs must be an array)")
+		return
+	}
+
+	blobs := make([][]byte, len(blobs_array))
+	for i, blob_interface := range blobs_array {
+		blob, ok := blob_interface.([]byte)
+		if !ok {
+			error_event <- errors.New(fmt.Sprintf("Invalid request (blob(%d) must be a binary)", i))
+			return
+		}
+		blobs[i] = blob
+	}
+
+	if _, err := context.StoreBlobs(blobs); err != nil {
+		error_event <- err
+		return
+	}
+
+	var err error = nil
+	for i:=0; i<5; i++ {		// retry 5times if failed...
+		if err = handler.writeSystemResult(c, 0); err == nil {
+			return
+		}
+	}
+
+	error_event <- errors.New("Failed to send system request: " + err.Error())
+}
+
+
+// invalid tickets are also reported as the result
+func acceptValidateTicketRequest(
+	data interface{},
+	c net.Conn,
+	context *Context,
+	handler *ProtocolHandler,
+	error_event chan<-error,
+) {
+	var report *ValidationReport
+	ticket, err := MakeTicketFromTuple(data)
+	if err != nil {
+		report = &ValidationReport{}
+		report.addError("ticket", err)
+	} else {
+		report = context.ValidateTicket(ticket)
+	}
+
+	for i:=0; i<5; i++ {		// retry 5times if failed...
+		if err = handler.writeValidationReport(c, report); err == nil {
+			return
+		}
+	}
+
+	error_event <- errors.New("Failed to send validation report: " + err.Error())
+}
+
+
 //
 func makeAddress(host string, port int) string {
 	return host + ":" + strconv.Itoa(port)
@@ -12,6 +12,7 @@ package torigoya
 
 import(
 	"fmt"
+	"log"
 	"errors"
 	"strconv"
 	"os"
@@ -38,6 +39,15 @@ type Context struct {
 
 	procSrcZipAddress	string
 	packageUpdater		PackageUpdater
+
+	historyStore		HistoryStore
+	buildCache			*BuildCache
+	resultCache			*ResultCache
+	blobStore			*BlobStore
+	cgroupRootPath		string
+	maxLimits			*PhaseLimits
+
+	runParallelism		int
 }
 
 
@@ -98,10 +108,86 @@ func InitContext(
 		procConfTable:		proc_conf_table,
 		procSrcZipAddress:	proc_src_zip_address,
 		packageUpdater:		package_updater,
+		runParallelism:		1,
 	}, nil
 }
 
 
+// history is not recorded if store is nil
+func (ctx *Context) SetHistoryStore(store HistoryStore) {
+	ctx.historyStore = store
+}
+
+
+// builds are always executed if cache is nil
+func (ctx *Context) SetBuildCache(cache *BuildCache) {
+	ctx.buildCache = cache
+}
+
+
+// results are never replayed if cache is nil
+func (ctx *Context) SetResultCache(cache *ResultCache) {
+	ctx.resultCache = cache
+}
+
+
+// blobs can not be uploaded or referenced if store is nil
+func (ctx *Context) SetBlobStore(store *BlobStore) {
+	ctx.blobStore = store
+}
+
+
+// profiles which use cgroup can be executed after this was set
+func (ctx *Context) SetCgroupRoot(root_path string) error {
+	if err := prepareCgroupRoot(root_path); err != nil {
+		return err
+	}
+	ctx.cgroupRootPath = root_path
+	return nil
+}
+
+
+// limits of tickets and profiles are clamped to this. nil means "no maximums"
+func (ctx *Context) SetMaxLimits(limits *PhaseLimits) {
+	ctx.maxLimits = limits
+}
+
+
+// the number of inputs of a ticket which are executed at the same time
+func (ctx *Context) SetRunParallelism(n int) {
+	if n < 1 { n = 1 }
+	ctx.runParallelism = n
+}
+
+
+func (ctx *Context) QueryHistory(query *HistoryQuery) ([]*HistoryRecord, error) {
+	if ctx.historyStore == nil {
+		return nil, errors.New("History Store was not registerd")
+	}
+
+	return ctx.historyStore.Query(query)
+}
+
+
+// returns hashes of the stored blobs
+func (ctx *Context) StoreBlobs(blobs [][]byte) ([]string, error) {
+	if ctx.blobStore == nil {
+		return nil, errors.New("Blob Store was not registerd")
+	}
+
+	hashes := make([]string, len(blobs))
+	for i, blob := range blobs {
+		hash, err := ctx.blobStore.Put(blob)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = hash
+	}
+
+	return hashes, nil
+}
+
+
 func (ctx *Context) HasProcTable() bool {
 	return ctx.procConfTable != nil
 }
@@ -114,9 +200,19 @@ func (ctx *Context) UpdatePackages() error {
 
 	err := ctx.packageUpdater.Update()
 
+	// compilers may have been changed even if the update was failed
+	if ctx.buildCache != nil {
+		if err := ctx.buildCache.Clear(); err != nil {
+			log.Printf("Failed to clear the build cache (%v)\n", err)
+		}
+	}
+	if ctx.resultCache != nil {
+		ctx.resultCache.Clear()
+	}
+
 	// TODO: fix it
     fmt.Printf("= /usr/local/torigoya ============================\n")
-	out, err := exec.Command("/bin/ls", "-la", "/usr/local/torigoya").Output()
+	out, err := commandOutput(exec.Command("/bin/ls", "-la", "/usr/local/torigoya"))
 	if err != nil {
 		fmt.Printf("error:: %s\n", err.Error())
 	} else {
@@ -138,6 +234,11 @@ func (ctx *Context) ReloadProcTable() error {
 	// rewrite
 	ctx.procConfTable = proc_conf_table
 
+	// results may be changed by new profiles
+	if ctx.resultCache != nil {
+		ctx.resultCache.Clear()
+	}
+
 	return nil
 }
 
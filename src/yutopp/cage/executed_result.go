@@ -9,6 +9,7 @@
 package torigoya
 
 import(
+	"fmt"
 	"syscall"
 
 	"github.com/ugorji/go/codec"
@@ -18,30 +19,156 @@ import(
 // Status of Result
 type ExecutedStatus		int
 const (
-    MemoryLimit		= ExecutedStatus(1)
-    CPULimit		= ExecutedStatus(2)
-    OutputLimit		= ExecutedStatus(22)
-    Error			= ExecutedStatus(3)
-    InvalidCommand	= ExecutedStatus(31)
-    Passed			= ExecutedStatus(4)
-    UnexpectedError	= ExecutedStatus(5)
+    MemoryLimit			= ExecutedStatus(1)
+    CPULimit			= ExecutedStatus(2)
+    WallTimeLimit		= ExecutedStatus(21)	// killed because it was running too long (e.g. sleeping)
+    OutputLimit			= ExecutedStatus(22)
+    Error				= ExecutedStatus(3)		// exited with non-zero code
+    InvalidCommand		= ExecutedStatus(31)	// failed to exec the command
+    RuntimeError		= ExecutedStatus(32)	// killed by a signal (e.g. SIGSEGV, SIGFPE, SIGABRT)
+    SandboxViolation	= ExecutedStatus(33)	// killed because of a forbidden system call (SIGSYS)
+    Passed				= ExecutedStatus(4)
+    UnexpectedError		= ExecutedStatus(5)
+    Skipped				= ExecutedStatus(6)		// not executed because of the policy of the ticket
 )
 
+func (s ExecutedStatus) String() string {
+	switch s {
+	case MemoryLimit:
+		return "MemoryLimit"
+	case CPULimit:
+		return "CPULimit"
+	case WallTimeLimit:
+		return "WallTimeLimit"
+	case OutputLimit:
+		return "OutputLimit"
+	case Error:
+		return "Error"
+	case InvalidCommand:
+		return "InvalidCommand"
+	case RuntimeError:
+		return "RuntimeError"
+	case SandboxViolation:
+		return "SandboxViolation"
+	case Passed:
+		return "Passed"
+	case UnexpectedError:
+		return "UnexpectedError"
+	case Skipped:
+		return "Skipped"
+	default:
+		return fmt.Sprintf("%d", s)
+	}
+}
+
+func (s ExecutedStatus) Description() string {
+	switch s {
+	case MemoryLimit:
+		return "memory limit exceeded"
+	case CPULimit:
+		return "CPU time limit exceeded"
+	case WallTimeLimit:
+		return "wall time limit exceeded"
+	case OutputLimit:
+		return "output limit exceeded"
+	case Error:
+		return "exited with non-zero code"
+	case InvalidCommand:
+		return "failed to execute the command"
+	case RuntimeError:
+		return "killed by a signal"
+	case SandboxViolation:
+		return "forbidden operation in the sandbox"
+	case Passed:
+		return "exited successfully"
+	case UnexpectedError:
+		return "unexpected error of the system"
+	case Skipped:
+		return "not executed"
+	default:
+		return "unknown status"
+	}
+}
+
+// status of the process which was killed by the signal (the watchdog and limits are checked before)
+func statusFromSignal(signal syscall.Signal) ExecutedStatus {
+	switch signal {
+	case syscall.SIGXCPU:
+		return CPULimit
+	case syscall.SIGXFSZ:
+		return OutputLimit
+	case syscall.SIGSYS:
+		return SandboxViolation
+	default:
+		return RuntimeError
+	}
+}
+
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGHUP: "SIGHUP",
+	syscall.SIGINT: "SIGINT",
+	syscall.SIGQUIT: "SIGQUIT",
+	syscall.SIGILL: "SIGILL",
+	syscall.SIGTRAP: "SIGTRAP",
+	syscall.SIGABRT: "SIGABRT",
+	syscall.SIGBUS: "SIGBUS",
+	syscall.SIGFPE: "SIGFPE",
+	syscall.SIGKILL: "SIGKILL",
+	syscall.SIGUSR1: "SIGUSR1",
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGUSR2: "SIGUSR2",
+	syscall.SIGPIPE: "SIGPIPE",
+	syscall.SIGALRM: "SIGALRM",
+	syscall.SIGTERM: "SIGTERM",
+	syscall.SIGXCPU: "SIGXCPU",
+	syscall.SIGXFSZ: "SIGXFSZ",
+	syscall.SIGSYS: "SIGSYS",
+}
+
+// e.g. "SIGSEGV (segmentation fault)"
+func signalName(signal syscall.Signal) string {
+	name, ok := signalNames[signal]
+	if !ok {
+		name = fmt.Sprintf("SIG(%d)", int(signal))
+	}
+	return fmt.Sprintf("%s (%s)", name, signal.String())
+}
+
 //
 type ExecutedResult struct {
-	UsedCPUTimeSec		float32
+	UsedCPUTimeSec		float64
+	UsedWallTimeSec		float64
+	UsedUserTimeSec		float64
+	UsedSystemTimeSec	float64
 	UsedMemoryBytes		uint64
+	MajorPageFaults		uint64
+	MinorPageFaults		uint64
+	VoluntaryContextSwitches	uint64
+	InvoluntaryContextSwitches	uint64
+	StdoutBytes			uint64		// 0 if stdout is connected to the peer
+	StderrBytes			uint64
+	PeakProcessCount	uint64		// sampled by the watchdog, so short-lived processes may be missed
+	PeakThreadCount		uint64
 	Signal				*syscall.Signal
 	ReturnCode			int
 	CommandLine			string
 	Status				ExecutedStatus
 	SystemErrorMessage	string
+	FileChanges			*FileChangeReport	// optional, nil unless reporting was requested
 }
 
 func (bm *ExecutedResult) IsFailed() bool {
 	return bm.Status != Passed;
 }
 
+// empty if the process was not killed by a signal
+func (bm *ExecutedResult) SignalName() string {
+	if bm.Signal == nil {
+		return ""
+	}
+	return signalName(*bm.Signal)
+}
+
 //
 func (bm *ExecutedResult) Encode() ([]byte, error) {
 	var msgpack_bytes []byte
@@ -64,5 +191,32 @@ func DecodeExecuteResult(base []byte) (*ExecutedResult, error) {
 
 //
 func (bm *ExecutedResult) ToTuple() []interface{} {
-	return []interface{}{ bm.UsedCPUTimeSec, bm.UsedMemoryBytes, bm.Signal, bm.ReturnCode, bm.CommandLine, bm.Status, bm.SystemErrorMessage}
+	var file_changes interface{} = nil
+	if bm.FileChanges != nil {
+		file_changes = bm.FileChanges.ToTuple()
+	}
+
+	return []interface{}{
+		bm.UsedCPUTimeSec,
+		bm.UsedMemoryBytes,
+		bm.Signal,
+		bm.ReturnCode,
+		bm.CommandLine,
+		bm.Status,
+		bm.SystemErrorMessage,
+		file_changes,
+		bm.UsedWallTimeSec,
+		bm.SignalName(),
+		bm.Status.Description(),
+		bm.UsedUserTimeSec,
+		bm.UsedSystemTimeSec,
+		bm.MajorPageFaults,
+		bm.MinorPageFaults,
+		bm.VoluntaryContextSwitches,
+		bm.InvoluntaryContextSwitches,
+		bm.StdoutBytes,
+		bm.StderrBytes,
+		bm.PeakProcessCount,
+		bm.PeakThreadCount,
+	}
 }
@@ -0,0 +1,50 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+package torigoya
+
+import (
+	"testing"
+	"syscall"
+)
+
+
+func TestUnitStatusFromSignal(t *testing.T) {
+	cases := []struct {
+		signal		syscall.Signal
+		status		ExecutedStatus
+	}{
+		{ syscall.SIGSEGV, RuntimeError },
+		{ syscall.SIGFPE, RuntimeError },
+		{ syscall.SIGABRT, RuntimeError },
+		{ syscall.SIGXCPU, CPULimit },
+		{ syscall.SIGXFSZ, OutputLimit },
+		{ syscall.SIGSYS, SandboxViolation },
+	}
+
+	for _, c := range cases {
+		if s := statusFromSignal(c.signal); s != c.status {
+			t.Fatalf("status of %s should be %v(but %v)", signalName(c.signal), c.status, s)
+		}
+	}
+}
+
+func TestUnitSignalName(t *testing.T) {
+	signal := syscall.SIGSEGV
+	result := &ExecutedResult{ Signal: &signal, Status: RuntimeError }
+	if result.SignalName() != "SIGSEGV (segmentation fault)" {
+		t.Fatalf("unexpected signal name (%s)", result.SignalName())
+	}
+	if (&ExecutedResult{}).SignalName() != "" {
+		t.Fatalf("signal name should be empty if the process was not signaled")
+	}
+
+	if RuntimeError.String() != "RuntimeError" || ExecutedStatus(99).String() != "99" {
+		t.Fatalf("unexpected status name (%s / %s)", RuntimeError, ExecutedStatus(99))
+	}
+}
@@ -0,0 +1,167 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import(
+	"os"
+	"sort"
+	"path/filepath"
+)
+
+
+// limitations of file change reports
+const (
+	maxSnapshotEntries		= 4096
+	maxReportedFileChanges	= 256
+)
+
+//
+type FileChangeKind		int
+const (
+	FileCreated		= FileChangeKind(0)
+	FileModified	= FileChangeKind(1)
+	FileDeleted		= FileChangeKind(2)
+)
+
+//
+type FileChange struct {
+	Path			string			// relative path from HOME
+	Kind			FileChangeKind
+	Size			uint64			// 0 if deleted
+	PreviousSize	uint64			// 0 if created
+}
+
+func (c *FileChange) ToTuple() []interface{} {
+	return []interface{}{ c.Path, c.Kind, c.Size, c.PreviousSize }
+}
+
+//
+type FileChangeReport struct {
+	Changes			[]*FileChange
+	IsTruncated		bool			// some changes were not reported because of the limitations
+}
+
+func (r *FileChangeReport) ToTuple() []interface{} {
+	changes := make([]interface{}, len(r.Changes))
+	for i, c := range r.Changes {
+		changes[i] = c.ToTuple()
+	}
+
+	return []interface{}{ changes, r.IsTruncated }
+}
+
+
+//
+type fileSnapshotEntry struct {
+	size			uint64
+	modTime			int64
+	mode			os.FileMode
+}
+
+type fileSnapshot struct {
+	entries			map[string]fileSnapshotEntry
+	isTruncated		bool
+}
+
+// directories are not recorded. files under read only directories are ignored
+func takeFileSnapshot(user_home_path string) (*fileSnapshot, error) {
+	snapshot := &fileSnapshot{
+		entries: map[string]fileSnapshotEntry{},
+	}
+
+	err := filepath.Walk(user_home_path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// the file may be removed while walking
+			if os.IsNotExist(err) { return nil }
+			return err
+		}
+		if path == user_home_path { return nil }
+
+		rel_path, err := filepath.Rel(user_home_path, path)
+		if err != nil { return err }
+
+		if info.IsDir() {
+			if isReadOnlyDirName(rel_path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if len(snapshot.entries) >= maxSnapshotEntries {
+			snapshot.isTruncated = true
+			return nil
+		}
+
+		snapshot.entries[rel_path] = fileSnapshotEntry{
+			size: uint64(info.Size()),
+			modTime: info.ModTime().UnixNano(),
+			mode: info.Mode(),
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+//
+func diffFileSnapshots(before, after *fileSnapshot) *FileChangeReport {
+	changes := []*FileChange{}
+
+	for path, a := range after.entries {
+		b, ok := before.entries[path]
+		switch {
+		case !ok:
+			changes = append(changes, &FileChange{
+				Path: path,
+				Kind: FileCreated,
+				Size: a.size,
+			})
+		case a != b:
+			changes = append(changes, &FileChange{
+				Path: path,
+				Kind: FileModified,
+				Size: a.size,
+				PreviousSize: b.size,
+			})
+		}
+	}
+	for path, b := range before.entries {
+		if _, ok := after.entries[path]; !ok {
+			changes = append(changes, &FileChange{
+				Path: path,
+				Kind: FileDeleted,
+				PreviousSize: b.size,
+			})
+		}
+	}
+
+	sort.Sort(fileChangesByPath(changes))
+
+	report := &FileChangeReport{
+		Changes: changes,
+		IsTruncated: before.isTruncated || after.isTruncated,
+	}
+	if len(report.Changes) > maxReportedFileChanges {
+		report.Changes = report.Changes[:maxReportedFileChanges]
+		report.IsTruncated = true
+	}
+
+	return report
+}
+
+type fileChangesByPath []*FileChange
+
+func (c fileChangesByPath) Len() int { return len(c) }
+func (c fileChangesByPath) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c fileChangesByPath) Less(i, j int) bool { return c[i].Path < c[j].Path }
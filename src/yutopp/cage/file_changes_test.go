@@ -0,0 +1,74 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import (
+	"testing"
+	"os"
+	"io/ioutil"
+	"path/filepath"
+)
+
+
+func TestUnitFileChangeReport(t *testing.T) {
+	home, err := ioutil.TempDir("", "torigoya_file_changes")
+	if err != nil { t.Fatalf("%v", err) }
+	defer os.RemoveAll(home)
+
+	os.MkdirAll(filepath.Join(home, "stdin"), 0700)
+	ioutil.WriteFile(filepath.Join(home, "prog.out"), []byte("binary"), 0600)
+	ioutil.WriteFile(filepath.Join(home, "keep.txt"), []byte("keep"), 0600)
+	ioutil.WriteFile(filepath.Join(home, "removed.txt"), []byte("removed"), 0600)
+
+	before, err := takeFileSnapshot(home)
+	if err != nil { t.Fatalf("%v", err) }
+
+	ioutil.WriteFile(filepath.Join(home, "prog.out"), []byte("modified binary"), 0600)
+	os.Remove(filepath.Join(home, "removed.txt"))
+	os.MkdirAll(filepath.Join(home, "tmp"), 0700)
+	ioutil.WriteFile(filepath.Join(home, "tmp", "scratch"), make([]byte, 1024), 0600)
+	ioutil.WriteFile(filepath.Join(home, "stdin", "ignored"), []byte("x"), 0600)
+
+	after, err := takeFileSnapshot(home)
+	if err != nil { t.Fatalf("%v", err) }
+
+	report := diffFileSnapshots(before, after)
+	if report.IsTruncated {
+		t.Fatalf("report should not be truncated")
+	}
+
+	expected := []FileChange{
+		FileChange{ "prog.out", FileModified, 15, 6 },
+		FileChange{ "removed.txt", FileDeleted, 0, 7 },
+		FileChange{ "tmp/scratch", FileCreated, 1024, 0 },
+	}
+	if len(report.Changes) != len(expected) {
+		t.Fatalf("%d changes should be reported (but %v)", len(expected), report.Changes)
+	}
+	for i, e := range expected {
+		if *report.Changes[i] != e {
+			t.Fatalf("expected %v (but %v)", e, *report.Changes[i])
+		}
+	}
+}
+
+func TestUnitFileChangeReportTruncation(t *testing.T) {
+	before := &fileSnapshot{ entries: map[string]fileSnapshotEntry{} }
+	after := &fileSnapshot{ entries: map[string]fileSnapshotEntry{} }
+	for i := 0; i <= maxReportedFileChanges; i++ {
+		after.entries[filepath.Join("out", string(rune('a' + i % 26)), string(rune('a' + i / 26)))] = fileSnapshotEntry{ size: 1 }
+	}
+
+	report := diffFileSnapshots(before, after)
+	if !report.IsTruncated || len(report.Changes) != maxReportedFileChanges {
+		t.Fatalf("report should be truncated to %d changes (but %d)", maxReportedFileChanges, len(report.Changes))
+	}
+}
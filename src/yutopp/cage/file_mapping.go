@@ -18,8 +18,11 @@ import(
 	"log"
 	"strconv"
 	"errors"
+	"io"
 	"os"
 	"os/exec"
+	"strings"
+	"syscall"
 	"path/filepath"
 )
 
@@ -162,7 +165,7 @@ func (ctx *Context) createMultipleTargetsWithDefaultName(
 	//// debug
 	defer func() {
 		log.Printf("==================================================\n")
-		out, err := exec.Command("/bin/ls", "-laR", user_home_path).Output()
+		out, err := commandOutput(exec.Command("/bin/ls", "-laR", user_home_path))
 		if err != nil {
 			log.Printf("error:: %s\n", err.Error())
 		} else {
@@ -174,24 +177,20 @@ func (ctx *Context) createMultipleTargetsWithDefaultName(
 	//// make source file
 	source_full_paths = make([]string, len(sources))
 	for index, source := range sources {
-		if len(source.Name) == 0 {
-			return nil, errors.New("source_file_name must NOT be empty")
+		source_name, err := resolveSourceName(source.Name, default_name)
+		if err != nil {
+			return nil, err
 		}
 
-		source_name := func() string {
-			if default_name == nil {
-				return source.Name
-			} else {
-				if source.Name == "*default*" {
-					return *default_name
-				} else {
-					return source.Name
-				}
-			}
-		}()
+		// create parent directories
+		source_dir_path, err := makeSourceDirectories(user_home_path, filepath.Dir(source_name), managed_user_id, managed_group_id)
+		if err != nil {
+			return nil, err
+		}
 
-		source_full_path := filepath.Join(user_home_path, source_name)
-		f, err := os.OpenFile(source_full_path, os.O_WRONLY|os.O_CREATE, 0600)
+		// the file must not exist (e.g. duplicated names, symlinks)
+		source_full_path := filepath.Join(source_dir_path, filepath.Base(source_name))
+		f, err := os.OpenFile(source_full_path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, 0600)
 		if err != nil {
 			return nil, err
 		}
@@ -212,6 +211,61 @@ func (ctx *Context) createMultipleTargetsWithDefaultName(
 	return source_full_paths, err
 }
 
+// the name of the file which is placed under HOME
+func resolveSourceName(name string, default_name *string) (string, error) {
+	source_name := name
+	if default_name != nil && name == "*default*" {
+		source_name = *default_name
+	}
+
+	if err := validateSourceName(source_name); err != nil {
+		return "", err
+	}
+	if isReadOnlyDirName(strings.Split(source_name, "/")[0]) {
+		return "", errors.New(fmt.Sprintf("source name %s is reserved", source_name))
+	}
+
+	return source_name, nil
+}
+
+// rel_dir_path must be validated. directories are owned by the managed user
+func makeSourceDirectories(
+	user_home_path		string,
+	rel_dir_path		string,
+	managed_user_id		int,
+	managed_group_id	int,
+) (string, error) {
+	dir_path := user_home_path
+	if rel_dir_path == "." {
+		return dir_path, nil
+	}
+
+	for _, component := range strings.Split(rel_dir_path, "/") {
+		dir_path = filepath.Join(dir_path, component)
+
+		info, err := os.Lstat(dir_path)
+		if err == nil {
+			if !info.IsDir() {
+				return "", errors.New(fmt.Sprintf("%s is not a directory", dir_path))
+			}
+			continue
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+
+		if err := os.Mkdir(dir_path, os.ModeDir); err != nil {
+			return "", errors.New(fmt.Sprintf("Couldn't create directory %s (%s)", dir_path, err))
+		}
+		// managed_user_id:managed_group_id // rwx/r-x/---
+		if err := guardPath(dir_path, managed_user_id, managed_group_id, 0750); err != nil {
+			return "", err
+		}
+	}
+
+	return dir_path, nil
+}
+
 
 //
 type reassignTargetCallback func(string) (*string, error)
@@ -255,7 +309,31 @@ func (ctx *Context) reassignTarget(
 		return "", nil, err
 	}
 
-	// call user block
+	// files under the read only directories are owned by the host, others are owned by the managed user
+	if err := filepath.Walk(user_home_path, func(path string, info os.FileInfo, err error) error {
+		if err != nil { return err }
+		if path == user_home_path { return nil }
+
+		rel_path, err := filepath.Rel(user_home_path, path)
+		if err != nil { return err }
+
+		owner_id := managed_user_id
+		if isReadOnlyDirName(strings.Split(rel_path, "/")[0]) {
+			owner_id = host_user_id
+		}
+
+		// NEVER follow symlinks which may be made by the managed user
+		if err := os.Lchown(path, owner_id, managed_group_id); err != nil {
+			return errors.New(fmt.Sprintf("Couldn't chown %s, %s", path, err.Error()))
+		}
+
+		log.Printf("reassgin::chown %s -> [%d] : (user group)[%d] \n", path, owner_id, managed_group_id)
+		return nil
+	}); err != nil {
+		return "", nil, err
+	}
+
+	// call user block. files made in it are NOT reassigned
 	if callback != nil {
 		input_path, err = callback(user_dir_path)
 		if err != nil {
@@ -268,32 +346,8 @@ func (ctx *Context) reassignTarget(
 		return "", nil, err
 	}
 
-	//
-	err = filepath.Walk(user_home_path, func(path string, info os.FileInfo, err error) error {
-		if err != nil { return err }
-		if !info.IsDir() {
-			if filepath.Dir(path) == user_home_path {
-				// files are placed under the home
-				if err := os.Chown(path, managed_user_id, managed_group_id); err != nil {
-					return errors.New(fmt.Sprintf("Couldn't chown %s, %s", path, err.Error()))
-				}
-
-				log.Printf("reassgin::chown %s -> (user)[%d] : (user group)[%d] \n", path, managed_user_id, managed_group_id)
-
-			} else {
-				//
-				if err := os.Chown(path, host_user_id, managed_group_id); err != nil {
-					return errors.New(fmt.Sprintf("Couldn't chown %s, %s", path, err.Error()))
-				}
-
-				log.Printf("reassgin::chown %s -> (host)[%d] : (user group)[%d] \n", path, host_user_id, managed_group_id)
-			}
-		}
-		return err
-	})
-
 	log.Printf("==================================================\n")
-	out, err := exec.Command("/bin/ls", "-laR", user_home_path).Output()
+	out, err := commandOutput(exec.Command("/bin/ls", "-laR", user_home_path))
 	if err != nil {
 		log.Printf("error:: %s\n", err.Error())
 	} else {
@@ -338,6 +392,14 @@ func (ctx *Context) cleanupMountedFiles(
 }
 
 
+// directories which contain files that can NOT be modified by the managed user
+const inputsDirName = "stdin"
+
+func isReadOnlyDirName(name string) bool {
+	return name == inputsDirName || name == judgeFilesDirName
+}
+
+
 func (ctx *Context) createInput(
 	base_dir_path		string,
 	managed_group_id	int,
@@ -345,6 +407,22 @@ func (ctx *Context) createInput(
 ) (stdin_full_path string, err error) {
 	log.Println("called SekiseiRunnerNodeServer::createInput")
 
+	full_paths, err := ctx.createReadOnlyFiles(base_dir_path, managed_group_id, inputsDirName, []*TextContent{ stdin })
+	if err != nil {
+		return "", err
+	}
+
+	return full_paths[0], nil
+}
+
+
+// files are placed into the directory under HOME, and can NOT be modified by the managed user
+func (ctx *Context) createReadOnlyFiles(
+	base_dir_path		string,
+	managed_group_id	int,
+	inputs_dir_name		string,
+	contents			[]*TextContent,
+) (full_paths []string, err error) {
     expectRoot()
 
 	// In posix, Uid only contains numbers
@@ -352,43 +430,273 @@ func (ctx *Context) createInput(
 	log.Printf("host uid: %s\n", ctx.hostUser.Uid)
 
 	//
-	const inputs_dir_name = "stdin"
 	inputs_dir_path := filepath.Join(base_dir_path, ctx.jailedUserDir, inputs_dir_name)
 
+	// e.g. a symlink which was made by the managed user
+	if info, err := os.Lstat(inputs_dir_path); err == nil && !info.IsDir() {
+		if err := os.Remove(inputs_dir_path); err != nil {
+			return nil, errors.New(fmt.Sprintf("Couldn't remove %s (%s)", inputs_dir_path, err))
+		}
+	}
+
 	//
 	if !fileExists(inputs_dir_path) {
 		err := os.Mkdir(inputs_dir_path, os.ModeDir)
 		if err != nil {
-			return "", errors.New(fmt.Sprintf("Couldn't create directory %s", inputs_dir_path))
+			return nil, errors.New(fmt.Sprintf("Couldn't create directory %s", inputs_dir_path))
 		}
 	}
 	// host_user_id:managed_group_id // rwx/---/---
 	if err := guardPath(inputs_dir_path, host_user_id, managed_group_id, 0700); err != nil {
-		return "", err
+		return nil, err
 	}
 
 	//
-	stdin_full_path = filepath.Join(inputs_dir_path, stdin.Name)
-	f, err := os.OpenFile(stdin_full_path, os.O_WRONLY|os.O_CREATE, 0440)	// r--/r--/---
+	for _, content := range contents {
+		full_path := filepath.Join(inputs_dir_path, content.Name)
+		if err := writeReadOnlyFile(full_path, host_user_id, managed_group_id, content.Data); err != nil {
+			return nil, err
+		}
+
+		full_paths = append(full_paths, full_path)
+	}
+
+	// change input DIR permission
+	// host_user_id:managed_group_id // r-x/r-x/---
+	if err := guardPath(inputs_dir_path, host_user_id, managed_group_id, 0550); err != nil {
+		return nil, err
+	}
+
+	return full_paths, nil
+}
+
+// data files are placed under HOME directly as read-only files. HOME is writable by the managed group,
+// so the managed user can still remove or replace them. they are removed after the input.
+// files which already exist (e.g. sources or the built program) are never replaced.
+// paths of files which were created are returned even if it failed, so that they can be removed
+func (ctx *Context) createDataFiles(
+	base_dir_path		string,
+	managed_user_id		int,
+	managed_group_id	int,
+	contents			[]*TextContent,
+) (full_paths []string, err error) {
+    expectRoot()
+
+	// In posix, Uid only contains numbers
+	host_user_id, _ := strconv.Atoi(ctx.hostUser.Uid)
+
+	//
+	user_home_path := filepath.Join(base_dir_path, ctx.jailedUserDir)
+	for _, content := range contents {
+		if err := validateDataFileName(content.Name, nil); err != nil {
+			return full_paths, err
+		}
+
+		dir_path, err := makeSourceDirectories(user_home_path, filepath.Dir(content.Name), managed_user_id, managed_group_id)
+		if err != nil {
+			return full_paths, err
+		}
+
+		full_path := filepath.Join(dir_path, filepath.Base(content.Name))
+		if _, err := os.Lstat(full_path); err == nil {
+			return full_paths, errors.New(fmt.Sprintf("data file %s conflicts with an existing file", content.Name))
+		} else if !os.IsNotExist(err) {
+			return full_paths, err
+		}
+
+		// host_user_id:managed_group_id // r--/r--/---
+		if err := writeReadOnlyFile(full_path, host_user_id, managed_group_id, content.Data); err != nil {
+			return full_paths, err
+		}
+
+		full_paths = append(full_paths, full_path)
+	}
+
+	return full_paths, nil
+}
+
+// workspace_names are files which are placed under HOME before data files (e.g. sources or the built program)
+func validateDataFileName(name string, workspace_names []string) error {
+	if err := validateSourceName(name); err != nil {
+		return err
+	}
+	if isReadOnlyDirName(strings.Split(name, "/")[0]) {
+		return errors.New(fmt.Sprintf("data file name %s is reserved", name))
+	}
+	for _, workspace_name := range workspace_names {
+		if name == workspace_name || strings.HasPrefix(name, workspace_name + "/") {
+			return errors.New(fmt.Sprintf("data file %s conflicts with %s in the workspace", name, workspace_name))
+		}
+	}
+
+	return nil
+}
+
+// names of files which are made by the build
+func makeBuildOutputNames(proc_profile *ProcProfile) []string {
+	if !proc_profile.IsBuildRequired {
+		return nil
+	}
+
+	names := []string{ makePhaseOutputName(&proc_profile.Compile) }
+	if proc_profile.IsLinkIndependent {
+		names = append(names, makePhaseOutputName(&proc_profile.Link))
+	}
+	return names
+}
+
+func makePhaseOutputName(phase *PhaseDetail) string {
+	if phase.Extension == "" {
+		return phase.File
+	}
+	return fmt.Sprintf("%s.%s", phase.File, phase.Extension)
+}
+
+func removeDataFiles(full_paths []string) {
+	for _, full_path := range full_paths {
+		if err := os.Remove(full_path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Couldn't remove data file %s (%v)\n", full_path, err)
+		}
+	}
+}
+
+func writeReadOnlyFile(
+	full_path			string,
+	user_id				int,
+	group_id			int,
+	data				[]byte,
+) (err error) {
+	f, err := os.OpenFile(full_path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, 0440)	// r--/r--/---
 	if err != nil {
-		return "", err
+		return err
 	}
 	defer func() {
  		f.Close()
-		// host_user_id:managed_group_id // r--/r--/---
-		err = guardPath(stdin_full_path, host_user_id, managed_group_id, 0440)
+		// user_id:group_id // r--/r--/---
+		if e := guardPath(full_path, user_id, group_id, 0440); e != nil && err == nil {
+			err = e
+		}
 	}()
-	if _, err := f.Write(stdin.Data); err != nil {
-		return "", err
+	if _, err := f.Write(data); err != nil {
+		return err
 	}
 
-	// change input DIR permission
-	// host_user_id:managed_group_id // r-x/r-x/---
-	if err := guardPath(inputs_dir_path, host_user_id, managed_group_id, 0550); err != nil {
-		return "", err
+	return nil
+}
+
+
+// copies HOME of the base_name into the new sandbox directory, so that it can be used by another process at the same time
+func (ctx *Context) cloneWorkspace(
+	base_name			string,
+	cloned_base_name	string,
+) error {
+	log.Printf("called file_mapping::cloneWorkspace %s -> %s\n", base_name, cloned_base_name)
+
+	user_home_path := filepath.Join(ctx.makeUserDirName(base_name), ctx.jailedUserDir)
+	return ctx.makeWorkspaceFromHome(user_home_path, cloned_base_name)
+}
+
+// makes the new sandbox directory of the base_name, HOME of it is a copy of src_home_path
+func (ctx *Context) makeWorkspaceFromHome(
+	src_home_path		string,
+	base_name			string,
+) error {
+	expectRoot()
+
+	// In posix, Uid only contains numbers
+	host_user_id, _ := strconv.Atoi(ctx.hostUser.Uid)
+
+	//
+	if err := ctx.removeWorkspace(base_name); err != nil {
+		return err
+	}
+
+	//
+	user_dir_path := ctx.makeUserDirName(base_name)
+	for _, p := range []string{
+		user_dir_path,
+		filepath.Join(user_dir_path, ctx.homeDir),
+	} {
+		if err := os.Mkdir(p, os.ModeDir); err != nil {
+			return errors.New(fmt.Sprintf("Couldn't create directory %s (%s)", p, err))
+		}
+		// host_user_id:host_user_id // r-x/r-x/---
+		if err := guardPath(p, host_user_id, host_user_id, 0550); err != nil {
+			return err
+		}
+	}
+
+	return copyHomeTree(src_home_path, filepath.Join(user_dir_path, ctx.jailedUserDir), host_user_id)
+}
+
+// symlinks are copied as is, and special files are ignored. copied files are owned by the host
+func copyHomeTree(
+	src_path			string,
+	dst_path			string,
+	host_user_id		int,
+) error {
+	return filepath.Walk(src_path, func(path string, info os.FileInfo, err error) error {
+		if err != nil { return err }
+
+		rel_path, err := filepath.Rel(src_path, path)
+		if err != nil { return err }
+		copied_path := filepath.Join(dst_path, rel_path)
+
+		switch {
+		case info.IsDir():
+			if err := os.Mkdir(copied_path, os.ModeDir); err != nil {
+				return errors.New(fmt.Sprintf("Couldn't create directory %s (%s)", copied_path, err))
+			}
+			return guardPath(copied_path, host_user_id, host_user_id, info.Mode().Perm())
+
+		case info.Mode() & os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil { return err }
+			return os.Symlink(link, copied_path)
+
+		case info.Mode().IsRegular():
+			return copyRegularFile(path, copied_path, host_user_id, info.Mode().Perm())
+
+		default:
+			log.Printf("copyHomeTree::skip %s (%v)\n", path, info.Mode())
+			return nil
+		}
+	})
+}
+
+func (ctx *Context) removeWorkspace(
+	base_name			string,
+) error {
+	user_dir_path := ctx.makeUserDirName(base_name)
+	if err := os.RemoveAll(user_dir_path); err != nil {
+		return errors.New(fmt.Sprintf("Couldn't remove directory %s (%s)", user_dir_path, err))
+	}
+
+	return nil
+}
+
+func copyRegularFile(
+	src_path			string,
+	dst_path			string,
+	host_user_id		int,
+	mode				os.FileMode,
+) error {
+	src, err := os.OpenFile(src_path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil { return err }
+	defer src.Close()
+
+	dst, err := os.OpenFile(dst_path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil { return err }
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
 	}
 
-	return stdin_full_path, err
+	// owners are reassigned before the execution
+	return guardPath(dst_path, host_user_id, host_user_id, mode)
 }
 
 
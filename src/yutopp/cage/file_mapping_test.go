@@ -0,0 +1,83 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import (
+	"testing"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+)
+
+func TestUnitCreateDataFiles(t *testing.T) {
+	base, err := ioutil.TempDir("", "torigoya_data_files")
+	if err != nil { t.Fatalf("%v", err) }
+	defer os.RemoveAll(base)
+
+	ctx := &Context{
+		hostUser: &user.User{ Uid: "0" },
+		jailedUserDir: "home/torigoya",
+	}
+	home := filepath.Join(base, ctx.jailedUserDir)
+	os.MkdirAll(home, 0750)
+	ioutil.WriteFile(filepath.Join(home, "prog.out"), []byte("binary"), 0550)
+
+	full_paths, err := ctx.createDataFiles(base, 1000, 1000, []*TextContent{
+		&TextContent{ Name: "data.txt", Data: []byte("data") },
+		&TextContent{ Name: "dir/nested.txt", Data: []byte("nested") },
+	})
+	if err != nil { t.Fatalf("%v", err) }
+	if len(full_paths) != 2 {
+		t.Fatalf("2 files should be created (but %v)", full_paths)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(home, "dir", "nested.txt")); err != nil || string(b) != "nested" {
+		t.Fatalf("dir/nested.txt should be created (%s, %v)", b, err)
+	}
+	if info, err := os.Stat(filepath.Join(home, "data.txt")); err != nil || info.Mode().Perm() != 0440 {
+		t.Fatalf("data.txt should be read only (%v, %v)", info, err)
+	}
+	removeDataFiles(full_paths)
+	if _, err := os.Stat(filepath.Join(home, "data.txt")); !os.IsNotExist(err) {
+		t.Fatalf("data.txt should be removed (%v)", err)
+	}
+
+	// files in the workspace are never replaced
+	full_paths, err = ctx.createDataFiles(base, 1000, 1000, []*TextContent{
+		&TextContent{ Name: "data.txt", Data: []byte("data") },
+		&TextContent{ Name: "prog.out", Data: []byte("replaced") },
+	})
+	if err == nil {
+		t.Fatalf("a data file which conflicts with prog.out should be rejected")
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(home, "prog.out")); err != nil || string(b) != "binary" {
+		t.Fatalf("prog.out should be kept (%s, %v)", b, err)
+	}
+	if len(full_paths) != 1 || full_paths[0] != filepath.Join(home, "data.txt") {
+		t.Fatalf("files which were created should be returned (but %v)", full_paths)
+	}
+	removeDataFiles(full_paths)
+}
+
+func TestUnitValidateDataFileName(t *testing.T) {
+	workspace_names := []string{ "prog.cpp", "lib", "prog.out" }
+
+	for _, name := range []string{ "data.txt", "dir/data.txt", "prog.cpp.txt", "library/a.txt" } {
+		if err := validateDataFileName(name, workspace_names); err != nil {
+			t.Fatalf("%s should be accepted (%v)", name, err)
+		}
+	}
+	for _, name := range []string{ "prog.cpp", "prog.out", "lib/a.txt", "../data.txt", "" } {
+		if err := validateDataFileName(name, workspace_names); err == nil {
+			t.Fatalf("%s should be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+package torigoya
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"bufio"
+	"sync"
+	"time"
+	"path/filepath"
+	"encoding/json"
+)
+
+
+// ========================================
+// a history store keeps metadata of executed tickets
+// after the connection has been closed
+type HistoryStore interface {
+	Record(record *HistoryRecord) error
+	Query(query *HistoryQuery) ([]*HistoryRecord, error)
+	Prune() error
+}
+
+
+// ========================================
+type HistoryPhase struct {
+	Index				int `json:"index"`
+	CpuTimeLimit		uint64 `json:"cpu_time_limit"`
+	MemoryBytesLimit	uint64 `json:"memory_bytes_limit"`
+	CpuTimeLimitMs		uint64 `json:"cpu_time_limit_ms"`
+	WallTimeLimitMs		uint64 `json:"wall_time_limit_ms"`
+	UsedCPUTimeSec		float64 `json:"used_cpu_time_sec"`
+	UsedWallTimeSec		float64 `json:"used_wall_time_sec"`
+	UsedMemoryBytes		uint64 `json:"used_memory_bytes"`
+	Status				ExecutedStatus `json:"status"`
+	IsFinished			bool `json:"is_finished"`
+}
+
+type HistoryRecord struct {
+	BaseName			string `json:"base_name"`
+	ProcId				uint64 `json:"proc_id"`
+	ProcVersion			string `json:"proc_version"`
+
+	StartedAt			time.Time `json:"started_at"`
+	FinishedAt			time.Time `json:"finished_at"`
+
+	SourceCount			int `json:"source_count"`
+	SourceBytes			uint64 `json:"source_bytes"`
+	InputCount			int `json:"input_count"`
+	InputBytes			uint64 `json:"input_bytes"`
+
+	Compile				*HistoryPhase `json:"compile"`
+	Link				*HistoryPhase `json:"link"`
+	Runs				[]*HistoryPhase `json:"runs"`
+
+	SystemErrorMessage	string `json:"system_error_message"`
+}
+
+func makeHistoryRecord(ticket *Ticket) *HistoryRecord {
+	record := &HistoryRecord{
+		BaseName: ticket.BaseName,
+		ProcId: ticket.ProcId,
+		ProcVersion: ticket.ProcVersion,
+		StartedAt: time.Now(),
+		SourceCount: len(ticket.Sources),
+	}
+
+	for _, s := range ticket.Sources {
+		if s != nil { record.SourceBytes += uint64(len(s.Data)) }
+	}
+
+	if ticket.BuildInst != nil {
+		record.Compile = makeHistoryPhase(0, ticket.BuildInst.CompileSetting)
+		record.Link = makeHistoryPhase(0, ticket.BuildInst.LinkSetting)
+	}
+
+	if ticket.RunInst != nil {
+		record.InputCount = len(ticket.RunInst.Inputs)
+		record.Runs = make([]*HistoryPhase, len(ticket.RunInst.Inputs))
+		for i, input := range ticket.RunInst.Inputs {
+			if input.stdin != nil { record.InputBytes += uint64(len(input.stdin.Data)) }
+			record.Runs[i] = makeHistoryPhase(i, input.setting)
+		}
+	}
+
+	return record
+}
+
+func makeHistoryPhase(index int, setting *ExecutionSetting) *HistoryPhase {
+	phase := &HistoryPhase{
+		Index: index,
+	}
+	if setting != nil {
+		phase.CpuTimeLimit = setting.CpuTimeLimit
+		phase.MemoryBytesLimit = setting.MemoryBytesLimit
+		phase.CpuTimeLimitMs = setting.cpuTimeLimitMs()
+		phase.WallTimeLimitMs = setting.wallTimeLimitMs()
+	}
+
+	return phase
+}
+
+func (hp *HistoryPhase) update(result *ExecutedResult) {
+	if result == nil { return }
+
+	hp.UsedCPUTimeSec = result.UsedCPUTimeSec
+	hp.UsedWallTimeSec = result.UsedWallTimeSec
+	hp.UsedMemoryBytes = result.UsedMemoryBytes
+	hp.Status = result.Status
+	hp.IsFinished = true
+}
+
+
+// ========================================
+// zero values mean "not specified"
+type HistoryQuery struct {
+	BaseName			string
+	ProcId				*uint64
+	Since, Until		time.Time
+	Limit				int
+}
+
+func (q *HistoryQuery) match(record *HistoryRecord) bool {
+	if q == nil { return true }
+
+	if q.BaseName != "" && q.BaseName != record.BaseName {
+		return false
+	}
+	if q.ProcId != nil && *q.ProcId != record.ProcId {
+		return false
+	}
+	if !q.Since.IsZero() && record.StartedAt.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && record.StartedAt.After(q.Until) {
+		return false
+	}
+
+	return true
+}
+
+func MakeHistoryQueryFromTuple(tupled interface{}) (*HistoryQuery, error) {
+	if tupled == nil { return &HistoryQuery{}, nil }
+	interface_array, ok := tupled.([]interface{})
+	if !ok { return nil, errors.New("HistoryQuery::invalid data(total)") }
+	if len(interface_array) != 5 { return nil, errors.New("HistoryQuery::invalid data(num of lement)") }
+
+	query := &HistoryQuery{}
+
+	//
+	if interface_array[0] != nil {
+		base_name_bytes, ok := interface_array[0].([]byte)
+		if !ok { return nil, errors.New("HistoryQuery::invalid data(0)") }
+		query.BaseName = string(base_name_bytes)
+	}
+
+	//
+	if interface_array[1] != nil {
+		proc_id, ok := readUInt(interface_array[1])
+		if !ok { return nil, errors.New("HistoryQuery::invalid data(1)") }
+		query.ProcId = &proc_id
+	}
+
+	// unix time(sec)
+	if interface_array[2] != nil {
+		since, ok := readUInt(interface_array[2])
+		if !ok { return nil, errors.New("HistoryQuery::invalid data(2)") }
+		query.Since = time.Unix(int64(since), 0)
+	}
+
+	// unix time(sec)
+	if interface_array[3] != nil {
+		until, ok := readUInt(interface_array[3])
+		if !ok { return nil, errors.New("HistoryQuery::invalid data(3)") }
+		query.Until = time.Unix(int64(until), 0)
+	}
+
+	//
+	if interface_array[4] != nil {
+		limit, ok := readUInt(interface_array[4])
+		if !ok { return nil, errors.New("HistoryQuery::invalid data(4)") }
+		query.Limit = int(limit)
+	}
+
+	return query, nil
+}
+
+
+// ========================================
+// zero values mean "unlimited"
+type HistoryRetention struct {
+	MaxAge				time.Duration
+	MaxRecords			int
+}
+
+func (r *HistoryRetention) IsEmpty() bool { return r.MaxAge == 0 && r.MaxRecords == 0 }
+
+func (r *HistoryRetention) apply(records []*HistoryRecord, now time.Time) []*HistoryRecord {
+	if r.MaxAge != 0 {
+		kept := []*HistoryRecord{}
+		for _, record := range records {
+			if now.Sub(record.StartedAt) <= r.MaxAge {
+				kept = append(kept, record)
+			}
+		}
+		records = kept
+	}
+
+	// records are ordered from old to new
+	if r.MaxRecords != 0 && len(records) > r.MaxRecords {
+		records = records[len(records) - r.MaxRecords:]
+	}
+
+	return records
+}
+
+
+// ========================================
+// default backend: append-only log. one JSON record per line
+type FileHistoryStore struct {
+	path				string
+	retention			HistoryRetention
+
+	mutex				sync.Mutex
+	appendedCount		int
+}
+
+// prune the log after this number of records were appended
+const historyCompactionInterval = 1000
+
+func NewFileHistoryStore(path string, retention HistoryRetention) (*FileHistoryStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errors.New(fmt.Sprintf("Couldn't create directory for history %s (%s)", path, err))
+	}
+
+	store := &FileHistoryStore{
+		path: path,
+		retention: retention,
+	}
+	if err := store.Prune(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (s *FileHistoryStore) Record(record *HistoryRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	line, err := json.Marshal(record)
+	if err != nil { return err }
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil { return err }
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	s.appendedCount++
+	if s.appendedCount >= historyCompactionInterval {
+		return s.pruneLocked()
+	}
+
+	return nil
+}
+
+// newer records come first
+func (s *FileHistoryStore) Query(query *HistoryQuery) ([]*HistoryRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	records, err := s.readAllLocked()
+	if err != nil { return nil, err }
+
+	records = s.retention.apply(records, time.Now())
+
+	result := []*HistoryRecord{}
+	for i := len(records)-1; i >= 0; i-- {
+		if !query.match(records[i]) {
+			continue
+		}
+
+		result = append(result, records[i])
+		if query != nil && query.Limit != 0 && len(result) >= query.Limit {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+func (s *FileHistoryStore) Prune() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.pruneLocked()
+}
+
+func (s *FileHistoryStore) pruneLocked() error {
+	s.appendedCount = 0
+	if s.retention.IsEmpty() {
+		return nil
+	}
+
+	records, err := s.readAllLocked()
+	if err != nil { return err }
+
+	kept := s.retention.apply(records, time.Now())
+	if len(kept) == len(records) {
+		return nil
+	}
+
+	// rewrite the log, then replace it atomically
+	tmp_path := s.path + ".tmp"
+	f, err := os.OpenFile(tmp_path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil { return err }
+
+	w := bufio.NewWriter(f)
+	for _, record := range kept {
+		line, err := json.Marshal(record)
+		if err != nil { f.Close(); return err }
+		if _, err := w.Write(append(line, '\n')); err != nil { f.Close(); return err }
+	}
+	if err := w.Flush(); err != nil { f.Close(); return err }
+	if err := f.Close(); err != nil { return err }
+
+	return os.Rename(tmp_path, s.path)
+}
+
+func (s *FileHistoryStore) readAllLocked() ([]*HistoryRecord, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) { return nil, nil }
+		return nil, err
+	}
+	defer f.Close()
+
+	records := []*HistoryRecord{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64 * 1024), 16 * 1024 * 1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 { continue }
+
+		record := &HistoryRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			// a broken line (e.g. crashed while appending) is skipped
+			continue
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
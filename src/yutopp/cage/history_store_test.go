@@ -0,0 +1,108 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+package torigoya
+
+import (
+	"testing"
+	"os"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+)
+
+
+func TestUnitFileHistoryStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "torigoya_history_")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileHistoryStore(filepath.Join(dir, "history.log"), HistoryRetention{})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	for i, name := range []string{"a", "b", "c"} {
+		record := &HistoryRecord{
+			BaseName: name,
+			ProcId: uint64(i % 2),
+			StartedAt: time.Now(),
+			Runs: []*HistoryPhase{
+				&HistoryPhase{ Status: Passed, IsFinished: true },
+			},
+		}
+		if err := store.Record(record); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+
+	records, err := store.Query(&HistoryQuery{})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("length of records should be 3(but %v)", len(records))
+	}
+	if records[0].BaseName != "c" {
+		t.Fatalf("records[0].BaseName should be c(but %v)", records[0].BaseName)
+	}
+	if records[2].Runs[0].Status != Passed {
+		t.Fatalf("records[2].Runs[0].Status should be Passed(but %v)", records[2].Runs[0].Status)
+	}
+
+	proc_id := uint64(0)
+	records, err = store.Query(&HistoryQuery{ ProcId: &proc_id, Limit: 1 })
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if len(records) != 1 || records[0].BaseName != "c" {
+		t.Fatalf("records should be [c](but %v)", records)
+	}
+}
+
+
+func TestUnitFileHistoryStoreRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "torigoya_history_")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "history.log")
+	store, err := NewFileHistoryStore(path, HistoryRetention{ MaxAge: time.Hour, MaxRecords: 2 })
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	store.Record(&HistoryRecord{ BaseName: "old", StartedAt: time.Now().Add(-2 * time.Hour) })
+	store.Record(&HistoryRecord{ BaseName: "a", StartedAt: time.Now() })
+	store.Record(&HistoryRecord{ BaseName: "b", StartedAt: time.Now() })
+	store.Record(&HistoryRecord{ BaseName: "c", StartedAt: time.Now() })
+
+	if err := store.Prune(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	// reopen
+	store, err = NewFileHistoryStore(path, HistoryRetention{})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	records, err := store.Query(nil)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("length of records should be 2(but %v)", len(records))
+	}
+	if records[0].BaseName != "c" || records[1].BaseName != "b" {
+		t.Fatalf("records should be [c, b](but [%v, %v])", records[0].BaseName, records[1].BaseName)
+	}
+}
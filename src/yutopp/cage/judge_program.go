@@ -0,0 +1,500 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import(
+	"log"
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"syscall"
+	"path"
+	"path/filepath"
+)
+
+
+//
+var judgeProgramBuildFailedError = errors.New("judge program build failed")
+
+// judge programs get these files as arguments
+const (
+	judgeFilesDirName	= "judge"
+	judgeInputName		= "input"
+	judgeOutputName		= "output"
+	judgeAnswerName		= "answer"
+)
+
+// a message longer than this will be truncated
+const judgeMessageLength = 1024
+
+// a checker which exited with this code writes a score in [0, 1] to stdout (e.g. "0.5")
+const checkerPartialScoreExitCode = 7
+
+
+// a judge program which is ready to run
+type preparedJudgeProgram struct {
+	program				*JudgeProgram
+	profile				*ProcProfile
+	baseName			string
+	runMode				int
+}
+
+
+// builds (or maps) the judge program once
+func (ctx *Context) prepareJudgeProgram(
+	program				*JudgeProgram,
+	base_name			string,
+	compile_mode		int,
+	link_mode			int,
+	run_mode			int,
+	callback			invokeResultRecieverCallback,
+) (*preparedJudgeProgram, error) {
+	log.Printf("$$$$$$$$$$ START prepare judge program => %s\n", base_name)
+	defer log.Printf("$$$$$$$$$$ FINISH prepare judge program => %s\n", base_name)
+
+	// lookup language proc profile
+	proc_profile, err := ctx.procConfTable.Find(program.ProcId, program.ProcVersion)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.checkResourceControl(proc_profile); err != nil {
+		return nil, err
+	}
+
+	// results are reported as results of the judge program
+	judge_callback := remapModeCallback(callback, map[int]int{
+		CompileMode: compile_mode,
+		LinkMode: link_mode,
+	})
+
+	//
+	if err := ctx.execManagedBuild(program.ProcId, program.ProcVersion, proc_profile, base_name, program.Sources, program.BuildInst, judge_callback); err != nil {
+		if err == buildFailedError {
+			return nil, judgeProgramBuildFailedError
+		} else {
+			return nil, err
+		}
+	}
+
+	// if it is build NOT required processor, sources have not been mapped yet
+	if ! proc_profile.IsBuildRequired {
+		if err := runAsManagedUser(func(jailed_user *JailedUserInfo) error {
+			return ctx.mapSources(base_name, program.Sources, jailed_user, proc_profile)
+
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return &preparedJudgeProgram{
+		program: program,
+		profile: proc_profile,
+		baseName: base_name,
+		runMode: run_mode,
+	}, nil
+}
+
+
+// makes a copy of the judge program which can be run with others at the same time
+func (ctx *Context) isolateJudgeProgram(
+	prepared			*preparedJudgeProgram,
+	index				int,
+) (*preparedJudgeProgram, error) {
+	isolated := *prepared
+	isolated.baseName = makeIsolatedBaseName(prepared.baseName, index)
+
+	if err := ctx.cloneWorkspace(prepared.baseName, isolated.baseName); err != nil {
+		return nil, err
+	}
+
+	return &isolated, nil
+}
+
+
+// paths of files in the jail are appended to the command line
+func makeJudgeRunSetting(
+	run_setting			*ExecutionSetting,
+	files				[]*TextContent,
+) *ExecutionSetting {
+	setting := *run_setting
+	setting.CommandLine = strings.TrimSpace(setting.CommandLine + " " + strings.Join(makeJudgeFilePaths(files), " "))
+
+	return &setting
+}
+
+func makeJudgeFilePaths(files []*TextContent) []string {
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = path.Join(judgeFilesDirName, f.Name)
+	}
+	return paths
+}
+
+
+// runs the checker in the jail with files of input, output of the program and answer
+func (ctx *Context) invokeCheckerCommand(
+	checker				*preparedJudgeProgram,
+	index				int,
+	input				*Input,
+	output				[]byte,
+	callback			invokeResultRecieverCallback,
+) (verdict *JudgeVerdict, err error) {
+	log.Println(">> called invokeCheckerCommand")
+
+	//
+	files := []*TextContent{
+		&TextContent{ Name: judgeInputName },
+		&TextContent{ Name: judgeOutputName, Data: output },
+		&TextContent{ Name: judgeAnswerName },
+	}
+	if input.stdin != nil {
+		stdin_content, err := convertSourceToContent(input.stdin)
+		if err != nil { return nil, err }
+		files[0].Data = stdin_content.Data
+	}
+	if input.expected != nil {
+		expected_content, err := convertSourceToContent(input.expected)
+		if err != nil { return nil, err }
+		files[2].Data = expected_content.Data
+	}
+
+	//
+	err = runAsManagedUser(func(jailed_user *JailedUserInfo) error {
+		user_dir_path, _, err := ctx.reassignTarget(
+			checker.baseName,
+			jailed_user.UserId,
+			jailed_user.GroupId,
+			func(base_directory_name string) (*string, error) {
+				_, err := ctx.createReadOnlyFiles(base_directory_name, jailed_user.GroupId, judgeFilesDirName, files)
+				return nil, err
+			},
+		)
+		if err != nil { return err }
+
+		//
+		setting := makeJudgeRunSetting(checker.program.RunSetting, files)
+
+		//
+		message := BridgeMessage{
+			ChrootPath: user_dir_path,
+			JailedUserHomePath: ctx.jailedUserDir,
+			JailedUser: jailed_user,
+			Message: ExecMessage{
+				Profile: checker.profile,
+				Setting: setting,
+				Mode: RunMode,
+			},
+			IsReboot: false,
+			CgroupRootPath: ctx.cgroupRootPath,
+			MaxLimits: ctx.maxLimits,
+		}
+
+		// stderr of the checker becomes the message of the verdict, and stdout may contain the score
+		stdout_buffer := bytes.NewBuffer(nil)
+		stderr_buffer := bytes.NewBuffer(nil)
+		checker_callback := func(v interface{}) {
+			if r, ok := v.(*StreamOutputResult); ok {
+				switch r.Output.Fd {
+				case StdoutFd:
+					stdout_buffer.Write(r.Output.Buffer)
+				case StderrFd:
+					stderr_buffer.Write(r.Output.Buffer)
+				}
+			}
+			if callback != nil {
+				callback(v)
+			}
+		}
+
+		//
+		checker_output_stream := make(chan *StreamOutput)
+		closed_ch := make(chan bool)
+		go sendOutputToCallback(checker_callback, checker_output_stream, checker.runMode, index, nil, closed_ch)
+
+		//
+		bin_base_path := filepath.Join(ctx.basePath, "bin")
+		result, err := message.invokeProcessCloner(bin_base_path, checker_output_stream, checker.baseName)
+
+		//
+		<-closed_ch
+		if err != nil { return err }
+		sendResultToCallback(callback, result, checker.runMode, index)
+
+		verdict = makeCheckerVerdict(result, stderr_buffer.Bytes(), stdout_buffer.Bytes())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return verdict, nil
+}
+
+// runs the program and the interactor at the same time. stdout of each side is connected to stdin of the other
+func (ctx *Context) invokeInteractiveRunCommand(
+	base_name			string,
+	proc_profile		*ProcProfile,
+	index				int,
+	input				*Input,
+	interactor			*preparedJudgeProgram,
+	callback			invokeResultRecieverCallback,
+) (*StreamExecutedResult, error) {
+	log.Println(">> called invokeInteractiveRunCommand")
+
+	//
+	files := []*TextContent{
+		&TextContent{ Name: judgeInputName },
+		&TextContent{ Name: judgeAnswerName },
+	}
+	if input.stdin != nil {
+		stdin_content, err := convertSourceToContent(input.stdin)
+		if err != nil { return nil, err }
+		files[0].Data = stdin_content.Data
+	}
+	if input.expected != nil {
+		expected_content, err := convertSourceToContent(input.expected)
+		if err != nil { return nil, err }
+		files[1].Data = expected_content.Data
+	}
+
+	// these fds are closed when the processes have been started
+	program_redirect, interactor_redirect, err := makeInteractionRedirects()
+	if err != nil { return nil, err }
+
+	// both sides report results at the same time
+	locked_callback := makeSerializedCallback(callback)
+
+	bin_base_path := filepath.Join(ctx.basePath, "bin")
+
+	// ========================================
+	var program_result *ExecutedResult = nil
+	program_err_ch := make(chan error)
+	go func() {
+		defer program_redirect.close()
+
+		program_err_ch <- runAsManagedUser(func(jailed_user *JailedUserInfo) error {
+			setting, err := makeInputSetting(proc_profile, input)
+			if err != nil { return err }
+
+			var data_file_paths []string = nil
+			user_dir_path, _, err := ctx.reassignTarget(
+				base_name,
+				jailed_user.UserId,
+				jailed_user.GroupId,
+				func(base_directory_name string) (*string, error) {
+					var err error
+					data_file_paths, err = ctx.createInputDataFiles(base_directory_name, jailed_user, input)
+					return nil, err
+				},
+			)
+			defer removeDataFiles(data_file_paths)
+			if err != nil { return err }
+
+			//
+			message := BridgeMessage{
+				ChrootPath: user_dir_path,
+				JailedUserHomePath: ctx.jailedUserDir,
+				JailedUser: jailed_user,
+				Redirect: program_redirect,
+				Message: ExecMessage{
+					Profile: proc_profile,
+					Setting: setting,
+					Mode: RunMode,
+				},
+				IsReboot: false,
+				CgroupRootPath: ctx.cgroupRootPath,
+				MaxLimits: ctx.maxLimits,
+			}
+
+			// stdout is connected to the interactor, so only stderr is streamed
+			run_output_stream := make(chan *StreamOutput)
+			closed_ch := make(chan bool)
+			go sendOutputToCallback(locked_callback, run_output_stream, RunMode, index, nil, closed_ch)
+
+			//
+			result, err := message.invokeProcessCloner(bin_base_path, run_output_stream, base_name)
+
+			//
+			<-closed_ch
+			program_result = result
+			return err
+		})
+	}()
+
+	// ========================================
+	var interactor_result *ExecutedResult = nil
+	stderr_buffer := bytes.NewBuffer(nil)
+	interactor_err_ch := make(chan error)
+	go func() {
+		defer interactor_redirect.close()
+
+		interactor_err_ch <- runAsManagedUser(func(jailed_user *JailedUserInfo) error {
+			user_dir_path, _, err := ctx.reassignTarget(
+				interactor.baseName,
+				jailed_user.UserId,
+				jailed_user.GroupId,
+				func(base_directory_name string) (*string, error) {
+					_, err := ctx.createReadOnlyFiles(base_directory_name, jailed_user.GroupId, judgeFilesDirName, files)
+					return nil, err
+				},
+			)
+			if err != nil { return err }
+
+			//
+			setting := makeJudgeRunSetting(interactor.program.RunSetting, files)
+
+			//
+			message := BridgeMessage{
+				ChrootPath: user_dir_path,
+				JailedUserHomePath: ctx.jailedUserDir,
+				JailedUser: jailed_user,
+				Redirect: interactor_redirect,
+				Message: ExecMessage{
+					Profile: interactor.profile,
+					Setting: setting,
+					Mode: RunMode,
+				},
+				IsReboot: false,
+				CgroupRootPath: ctx.cgroupRootPath,
+				MaxLimits: ctx.maxLimits,
+			}
+
+			// stderr of the interactor becomes the message of the verdict
+			interactor_callback := func(v interface{}) {
+				if r, ok := v.(*StreamOutputResult); ok && r.Output.Fd == StderrFd {
+					stderr_buffer.Write(r.Output.Buffer)
+				}
+				locked_callback(v)
+			}
+
+			//
+			interactor_output_stream := make(chan *StreamOutput)
+			closed_ch := make(chan bool)
+			go sendOutputToCallback(interactor_callback, interactor_output_stream, interactor.runMode, index, nil, closed_ch)
+
+			//
+			result, err := message.invokeProcessCloner(bin_base_path, interactor_output_stream, interactor.baseName)
+
+			//
+			<-closed_ch
+			interactor_result = result
+			return err
+		})
+	}()
+
+	//
+	program_err := <-program_err_ch
+	interactor_err := <-interactor_err_ch
+	if program_err != nil { return nil, program_err }
+	if interactor_err != nil { return nil, interactor_err }
+
+	//
+	sendResultToCallback(callback, interactor_result, interactor.runMode, index)
+
+	var verdict *JudgeVerdict = nil
+	if !program_result.IsFailed() {
+		verdict = makeInteractorVerdict(interactor_result, stderr_buffer.Bytes())
+	}
+	return sendJudgedResultToCallback(callback, program_result, verdict, RunMode, index), nil
+}
+
+// stdin/stdout of the program and the interactor are connected each other. all fds are close-on-exec
+func makeInteractionRedirects() (*RedirectFds, *RedirectFds, error) {
+	// program(stdout) -> interactor(stdin)
+	to_interactor, err := makePipeCloseOnExec()
+	if err != nil { return nil, nil, err }
+	// interactor(stdout) -> program(stdin)
+	to_program, err := makePipeCloseOnExec()
+	if err != nil {
+		to_interactor.Close()
+		return nil, nil, err
+	}
+
+	program_redirect := &RedirectFds{ Stdin: to_program.ReadFd, Stdout: to_interactor.WriteFd }
+	interactor_redirect := &RedirectFds{ Stdin: to_interactor.ReadFd, Stdout: to_program.WriteFd }
+	return program_redirect, interactor_redirect, nil
+}
+
+// the interactor is killed by SIGPIPE when the program exited before the interaction was finished
+func makeInteractorVerdict(result *ExecutedResult, message []byte) *JudgeVerdict {
+	if result.Signal != nil && *result.Signal == syscall.SIGPIPE {
+		return &JudgeVerdict{
+			Status: WrongAnswer,
+			Message: "program exited before the interaction was finished",
+		}
+	}
+
+	return makeCheckerVerdict(result, message, nil)
+}
+
+// exit code 0: accepted, 1(wrong answer)/2(presentation error): wrong answer,
+// checkerPartialScoreExitCode: the score in stdout is given, others: failed
+func makeCheckerVerdict(result *ExecutedResult, message []byte, score_output []byte) *JudgeVerdict {
+	message = bytes.TrimSpace(message)
+	if len(message) > judgeMessageLength {
+		message = message[:judgeMessageLength]
+	}
+
+	status, score := func() (JudgeStatus, float64) {
+		if result.Status == Passed {
+			return Accepted, 1
+		}
+		if result.Status != Error || result.Signal != nil {
+			return JudgeFailed, 0
+		}
+
+		switch result.ReturnCode {
+		case 1, 2:
+			return WrongAnswer, 0
+		case checkerPartialScoreExitCode:
+			score, err := strconv.ParseFloat(string(bytes.TrimSpace(score_output)), 64)
+			switch {
+			case err != nil || !(score >= 0 && score <= 1):
+				return JudgeFailed, 0
+			case score == 0:
+				return WrongAnswer, 0
+			case score == 1:
+				return Accepted, 1
+			default:
+				return PartiallyAccepted, score
+			}
+		default:
+			return JudgeFailed, 0
+		}
+	}()
+
+	return &JudgeVerdict{
+		Status: status,
+		Message: string(message),
+		Score: score,
+	}
+}
+
+
+// rewrites modes of results
+func remapModeCallback(
+	callback			invokeResultRecieverCallback,
+	mode_map			map[int]int,
+) invokeResultRecieverCallback {
+	return func(v interface{}) {
+		switch r := v.(type) {
+		case *StreamOutputResult:
+			if m, ok := mode_map[r.Mode]; ok { r.Mode = m }
+		case *StreamExecutedResult:
+			if m, ok := mode_map[r.Mode]; ok { r.Mode = m }
+		}
+
+		if callback != nil {
+			callback(v)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import (
+	"testing"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+
+func signalPtr(s syscall.Signal) *syscall.Signal {
+	return &s
+}
+
+func TestUnitMakeCheckerVerdict(t *testing.T) {
+	cases := []struct {
+		result				*ExecutedResult
+		status				JudgeStatus
+	}{
+		{ &ExecutedResult{ Status: Passed, ReturnCode: 0 }, Accepted },
+		{ &ExecutedResult{ Status: Error, ReturnCode: 1 }, WrongAnswer },
+		{ &ExecutedResult{ Status: Error, ReturnCode: 2 }, WrongAnswer },
+		{ &ExecutedResult{ Status: Error, ReturnCode: 3 }, JudgeFailed },
+		{ &ExecutedResult{ Status: Error, ReturnCode: 255 }, JudgeFailed },
+		// killed by signals
+		{ &ExecutedResult{ Status: RuntimeError, Signal: signalPtr(syscall.SIGSEGV) }, JudgeFailed },
+		{ &ExecutedResult{ Status: Error, ReturnCode: 1, Signal: signalPtr(syscall.SIGABRT) }, JudgeFailed },
+		// timed out
+		{ &ExecutedResult{ Status: CPULimit, Signal: signalPtr(syscall.SIGKILL) }, JudgeFailed },
+		{ &ExecutedResult{ Status: WallTimeLimit, Signal: signalPtr(syscall.SIGKILL) }, JudgeFailed },
+		{ &ExecutedResult{ Status: MemoryLimit, ReturnCode: 1 }, JudgeFailed },
+	}
+
+	for i, c := range cases {
+		verdict := makeCheckerVerdict(c.result, []byte(" message \n"), nil)
+		if verdict.Status != c.status {
+			t.Fatalf("case %d: status should be %v(but %v)", i, c.status, verdict.Status)
+		}
+		if verdict.Message != "message" {
+			t.Fatalf("case %d: message should be trimmed (%s)", i, verdict.Message)
+		}
+	}
+
+	// partial scores are written to stdout
+	partial_cases := []struct {
+		output				string
+		status				JudgeStatus
+		score				float64
+	}{
+		{ "0.25\n", PartiallyAccepted, 0.25 },
+		{ "1", Accepted, 1 },
+		{ "0", WrongAnswer, 0 },
+		{ "1.5", JudgeFailed, 0 },
+		{ "-0.5", JudgeFailed, 0 },
+		{ "NaN", JudgeFailed, 0 },
+		{ "", JudgeFailed, 0 },
+	}
+	for i, c := range partial_cases {
+		result := &ExecutedResult{ Status: Error, ReturnCode: checkerPartialScoreExitCode }
+		verdict := makeCheckerVerdict(result, nil, []byte(c.output))
+		if verdict.Status != c.status || verdict.Score != c.score {
+			t.Fatalf("case %d: verdict should be %v/%v (but %v/%v)", i, c.status, c.score, verdict.Status, verdict.Score)
+		}
+	}
+	if verdict := makeCheckerVerdict(&ExecutedResult{ Status: Passed }, nil, []byte("0.5")); verdict.Score != 1 {
+		t.Fatalf("accepted input should be scored 1 (but %v)", verdict.Score)
+	}
+
+	// long messages are cut
+	verdict := makeCheckerVerdict(&ExecutedResult{ Status: Passed }, []byte(strings.Repeat("a", judgeMessageLength * 2)), nil)
+	if len(verdict.Message) != judgeMessageLength {
+		t.Fatalf("message should be cut to %d bytes (but %d)", judgeMessageLength, len(verdict.Message))
+	}
+}
+
+func TestUnitMakeInteractorVerdict(t *testing.T) {
+	// the program exited before the interaction was finished
+	verdict := makeInteractorVerdict(&ExecutedResult{ Status: RuntimeError, Signal: signalPtr(syscall.SIGPIPE) }, []byte("ignored"))
+	if verdict.Status != WrongAnswer || verdict.Message == "ignored" {
+		t.Fatalf("SIGPIPE should be WrongAnswer (%v / %s)", verdict.Status, verdict.Message)
+	}
+
+	// others are same as checkers
+	if v := makeInteractorVerdict(&ExecutedResult{ Status: Passed }, nil); v.Status != Accepted {
+		t.Fatalf("status should be Accepted(but %v)", v.Status)
+	}
+	if v := makeInteractorVerdict(&ExecutedResult{ Status: Error, ReturnCode: 1 }, nil); v.Status != WrongAnswer {
+		t.Fatalf("status should be WrongAnswer(but %v)", v.Status)
+	}
+	if v := makeInteractorVerdict(&ExecutedResult{ Status: RuntimeError, Signal: signalPtr(syscall.SIGSEGV) }, nil); v.Status != JudgeFailed {
+		t.Fatalf("status should be JudgeFailed(but %v)", v.Status)
+	}
+}
+
+func isCloseOnExec(t *testing.T, fd int) bool {
+	flags, _, errno := syscall.RawSyscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFD, 0)
+	if errno != 0 { t.Fatalf("fcntl(%d): %v", fd, errno) }
+	return flags & syscall.FD_CLOEXEC != 0
+}
+
+// returns data until EOF, or fails if EOF doesn't come
+func readUntilEOF(t *testing.T, fd int) string {
+	ch := make(chan []byte)
+	go func() {
+		b, _ := readPipe(fd)
+		ch <- b
+	}()
+
+	select {
+	case b := <-ch:
+		return string(b)
+	case <-time.After(2 * time.Second):
+		t.Fatalf("EOF was not detected (fd: %d)", fd)
+		return ""
+	}
+}
+
+func TestUnitInteractionRedirects(t *testing.T) {
+	program, interactor, err := makeInteractionRedirects()
+	if err != nil { t.Fatalf("%v", err) }
+	defer program.close()
+	defer interactor.close()
+
+	for _, fd := range []int{ program.Stdin, program.Stdout, interactor.Stdin, interactor.Stdout } {
+		if !isCloseOnExec(t, fd) {
+			t.Fatalf("fd %d should be close-on-exec", fd)
+		}
+	}
+
+	// other processes must not keep fds of the interaction open
+	cmd := exec.Command("sleep", "10")
+	if err := startCommand(cmd); err != nil { t.Fatalf("%v", err) }
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	// program(stdout) -> interactor(stdin)
+	if err := writeAllToFd(program.Stdout, []byte("query")); err != nil { t.Fatalf("%v", err) }
+	syscall.Close(program.Stdout)
+	program.Stdout = -1
+	if s := readUntilEOF(t, interactor.Stdin); s != "query" {
+		t.Fatalf("interactor should receive \"query\" (but %s)", s)
+	}
+
+	// interactor(stdout) -> program(stdin)
+	if err := writeAllToFd(interactor.Stdout, []byte("answer")); err != nil { t.Fatalf("%v", err) }
+	syscall.Close(interactor.Stdout)
+	interactor.Stdout = -1
+	if s := readUntilEOF(t, program.Stdin); s != "answer" {
+		t.Fatalf("program should receive \"answer\" (but %s)", s)
+	}
+}
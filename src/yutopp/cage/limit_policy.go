@@ -0,0 +1,151 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import(
+	"strconv"
+)
+
+
+// used if neither the ticket nor the profile specifies limits
+var defaultPhaseLimits = map[int]PhaseLimits{
+	CompileMode: PhaseLimits{
+		FileSizeBytes: 5 * 1024 * 1024,			// Process can writes a file only 5MiB
+		StdoutBytes: 1 * 1024 * 1024,
+		StderrBytes: 1 * 1024 * 1024,
+		OpenFiles: 512,
+		Processes: 30,
+		LockedMemoryBytes: 1024,
+		StackBytes: 8 * 1024 * 1024,
+	},
+	LinkMode: PhaseLimits{
+		CpuTimeMs: 10 * 1000,					// 10sec
+		MemoryBytes: 2 * 1024 * 1024 * 1024,	// 2GiB
+		WallTimeMs: 15 * 1000,					// 15sec
+		FileSizeBytes: 40 * 1024 * 1024,		// Process can writes a file only 40MiB
+		StdoutBytes: 1 * 1024 * 1024,
+		StderrBytes: 1 * 1024 * 1024,
+		OpenFiles: 512,
+		Processes: 30,
+		LockedMemoryBytes: 1024,
+		StackBytes: 8 * 1024 * 1024,
+	},
+	RunMode: PhaseLimits{
+		FileSizeBytes: 512 * 1024,				// Process can writes a file only 512KiB
+		StdoutBytes: 64 * 1024 * 1024,
+		StderrBytes: 1 * 1024 * 1024,
+		OpenFiles: 512,
+		Processes: 30,
+		LockedMemoryBytes: 1024,
+		StackBytes: 8 * 1024 * 1024,
+	},
+}
+
+// limits are taken in order of the ticket, the profile and the default, then clamped to maximums of the server.
+// limits of the link phase are taken from the ticket only if the setting overrides them.
+// the stack is also bounded by the profile and the memory limit, except the unlimited stack which is allowed by them
+func makeResourceLimit(
+	mode				int,
+	profile_limits		*PhaseLimits,		// optional
+	exec_setting		*ExecutionSetting,
+	max_limits			*PhaseLimits,		// optional
+) *ResourceLimit {
+	if profile_limits == nil { profile_limits = &PhaseLimits{} }
+	if max_limits == nil { max_limits = &PhaseLimits{} }
+	default_limits := defaultPhaseLimits[mode]
+
+	ticket_limits := &PhaseLimits{
+		StdoutBytes: exec_setting.StdoutLimitBytes,
+		StderrBytes: exec_setting.StderrLimitBytes,
+	}
+	is_ticket_limited := mode != LinkMode || exec_setting.OverrideLimits
+	if is_ticket_limited {
+		ticket_limits.CpuTimeMs = exec_setting.cpuTimeLimitMs()
+		ticket_limits.MemoryBytes = exec_setting.MemoryBytesLimit
+		ticket_limits.WallTimeMs = exec_setting.wallTimeLimitMs()
+	}
+
+	limit := func(pick func(l *PhaseLimits) uint64) uint64 {
+		v := pickLimit(pick(ticket_limits), pick(profile_limits), pick(&default_limits))
+		return clampLimit(v, pick(max_limits))
+	}
+
+	rl := &ResourceLimit{
+		CPUMs: limit(func(l *PhaseLimits) uint64 { return l.CpuTimeMs }),
+		AS: limit(func(l *PhaseLimits) uint64 { return l.MemoryBytes }),
+		FSize: limit(func(l *PhaseLimits) uint64 { return l.FileSizeBytes }),
+		WallMs: limit(func(l *PhaseLimits) uint64 { return l.WallTimeMs }),
+		Stdout: limit(func(l *PhaseLimits) uint64 { return l.StdoutBytes }),
+		Stderr: limit(func(l *PhaseLimits) uint64 { return l.StderrBytes }),
+		NOFile: limit(func(l *PhaseLimits) uint64 { return l.OpenFiles }),
+		NProc: limit(func(l *PhaseLimits) uint64 { return l.Processes }),
+		MemLock: limit(func(l *PhaseLimits) uint64 { return l.LockedMemoryBytes }),
+	}
+
+	//
+	var stack uint64 = 0
+	if is_ticket_limited {
+		stack = exec_setting.StackLimitBytes
+		if exec_setting.IsStackSameAsMemory {
+			stack = rl.AS
+		}
+	}
+	if stack == StackUnlimited && isStackUnbounded(profile_limits.StackBytes) && isStackUnbounded(max_limits.StackBytes) {
+		// the memory limit still bounds the total usage
+		rl.Stack = StackUnlimited
+		return rl
+	}
+	stack = pickLimit(stack, default_limits.StackBytes)
+	stack = clampLimit(stack, profile_limits.StackBytes)
+	stack = clampLimit(stack, max_limits.StackBytes)
+	rl.Stack = clampLimit(stack, rl.AS)
+
+	return rl
+}
+
+func isStackUnbounded(max uint64) bool {
+	return max == 0 || max == StackUnlimited
+}
+
+// the stack limit is passed to runtimes which decide sizes of stacks by themselves.
+// the unlimited stack is not passed, so that runtimes use their defaults
+func makeStackSizeEnv(env map[string]string, stack_size_env string, stack_bytes uint64) map[string]string {
+	if stack_size_env == "" || stack_bytes == StackUnlimited {
+		return env
+	}
+
+	// env of the profile must not be modified
+	new_env := make(map[string]string)
+	for k, v := range env {
+		new_env[k] = v
+	}
+	new_env[stack_size_env] = strconv.FormatUint(stack_bytes, 10)
+
+	return new_env
+}
+
+// the first value which is not 0
+func pickLimit(values ...uint64) uint64 {
+	for _, v := range values {
+		if v != 0 {
+			return v
+		}
+	}
+	return 0
+}
+
+// 0 means "not specified" for both of them
+func clampLimit(v uint64, max uint64) uint64 {
+	if max != 0 && (v == 0 || v > max) {
+		return max
+	}
+	return v
+}
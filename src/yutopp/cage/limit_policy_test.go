@@ -0,0 +1,129 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import (
+	"testing"
+)
+
+
+func TestUnitMakeResourceLimit(t *testing.T) {
+	setting := &ExecutionSetting{
+		CpuTimeLimit: 3,
+		MemoryBytesLimit: 256 * 1024 * 1024,
+	}
+
+	// ticket > default
+	rl := makeResourceLimit(RunMode, nil, setting, nil)
+	if rl.CPUMs != 3000 || rl.AS != 256 * 1024 * 1024 || rl.WallMs != 8000 || rl.FSize != 512 * 1024 || rl.NProc != 30 {
+		t.Fatalf("unexpected limits of run (%+v)", rl)
+	}
+
+	// profile > default
+	profile_limits := &PhaseLimits{ FileSizeBytes: 1024, Processes: 4, CpuTimeMs: 1000 }
+	rl = makeResourceLimit(RunMode, profile_limits, setting, nil)
+	if rl.CPUMs != 3000 || rl.FSize != 1024 || rl.NProc != 4 || rl.NOFile != 512 {
+		t.Fatalf("limits of the profile should be used (%+v)", rl)
+	}
+
+	// limits of the ticket are ignored in the link phase unless the ticket overrides them
+	rl = makeResourceLimit(LinkMode, nil, setting, nil)
+	if rl.CPUMs != 10 * 1000 || rl.AS != 2 * 1024 * 1024 * 1024 || rl.WallMs != 15 * 1000 {
+		t.Fatalf("fixed limits of link should be used (%+v)", rl)
+	}
+	rl = makeResourceLimit(LinkMode, profile_limits, setting, nil)
+	if rl.CPUMs != 1000 {
+		t.Fatalf("limits of the profile should be used for link (%+v)", rl)
+	}
+	override_setting := *setting
+	override_setting.OverrideLimits = true
+	rl = makeResourceLimit(LinkMode, profile_limits, &override_setting, nil)
+	if rl.CPUMs != 3000 || rl.AS != 256 * 1024 * 1024 {
+		t.Fatalf("limits of the ticket should be used for link (%+v)", rl)
+	}
+
+	// clamped to maximums of the server
+	max_limits := &PhaseLimits{ CpuTimeMs: 2000, StdoutBytes: 1024 }
+	rl = makeResourceLimit(RunMode, nil, setting, max_limits)
+	if rl.CPUMs != 2000 || rl.Stdout != 1024 || rl.AS != 256 * 1024 * 1024 {
+		t.Fatalf("limits should be clamped (%+v)", rl)
+	}
+}
+
+func TestUnitMakeStackLimit(t *testing.T) {
+	setting := &ExecutionSetting{
+		CpuTimeLimit: 3,
+		MemoryBytesLimit: 256 * 1024 * 1024,
+	}
+
+	if rl := makeResourceLimit(RunMode, nil, setting, nil); rl.Stack != 8 * 1024 * 1024 {
+		t.Fatalf("default stack should be 8MiB (%d)", rl.Stack)
+	}
+
+	// stack = memory limit, bounded by the profile and the server
+	same_setting := *setting
+	same_setting.IsStackSameAsMemory = true
+	if rl := makeResourceLimit(RunMode, nil, &same_setting, nil); rl.Stack != 256 * 1024 * 1024 {
+		t.Fatalf("stack should be same as the memory limit (%d)", rl.Stack)
+	}
+	if rl := makeResourceLimit(RunMode, &PhaseLimits{ StackBytes: 64 * 1024 * 1024 }, &same_setting, nil); rl.Stack != 64 * 1024 * 1024 {
+		t.Fatalf("stack should be bounded by the profile (%d)", rl.Stack)
+	}
+	if rl := makeResourceLimit(RunMode, nil, &same_setting, &PhaseLimits{ StackBytes: 32 * 1024 * 1024 }); rl.Stack != 32 * 1024 * 1024 {
+		t.Fatalf("stack should be bounded by the server (%d)", rl.Stack)
+	}
+
+	// never exceeds the memory limit
+	large_setting := *setting
+	large_setting.StackLimitBytes = 1024 * 1024 * 1024
+	if rl := makeResourceLimit(RunMode, nil, &large_setting, nil); rl.Stack != 256 * 1024 * 1024 {
+		t.Fatalf("stack should be bounded by the memory limit (%d)", rl.Stack)
+	}
+
+	// unlimited, only if the profile and the server allow it
+	unlimited_setting := *setting
+	unlimited_setting.StackLimitBytes = StackUnlimited
+	if rl := makeResourceLimit(RunMode, nil, &unlimited_setting, nil); rl.Stack != StackUnlimited {
+		t.Fatalf("stack should be unlimited (%d)", rl.Stack)
+	}
+	if rl := makeResourceLimit(RunMode, nil, &unlimited_setting, &PhaseLimits{ StackBytes: StackUnlimited }); rl.Stack != StackUnlimited {
+		t.Fatalf("stack should be unlimited if the server allows it (%d)", rl.Stack)
+	}
+	if rl := makeResourceLimit(RunMode, nil, &unlimited_setting, &PhaseLimits{ StackBytes: 32 * 1024 * 1024 }); rl.Stack != 32 * 1024 * 1024 {
+		t.Fatalf("unlimited stack should be bounded by the server (%d)", rl.Stack)
+	}
+	if rl := makeResourceLimit(RunMode, &PhaseLimits{ StackBytes: 64 * 1024 * 1024 }, &unlimited_setting, nil); rl.Stack != 64 * 1024 * 1024 {
+		t.Fatalf("unlimited stack should be bounded by the profile (%d)", rl.Stack)
+	}
+	if rl := makeResourceLimit(LinkMode, nil, &unlimited_setting, nil); rl.Stack != 8 * 1024 * 1024 {
+		t.Fatalf("stack of the link phase should not be taken from the ticket (%d)", rl.Stack)
+	}
+}
+
+func TestUnitMakeStackSizeEnv(t *testing.T) {
+	env := map[string]string{ "PATH": "/usr/bin" }
+
+	if e := makeStackSizeEnv(env, "", 1024); len(e) != 1 {
+		t.Fatalf("env should not be changed (%v)", e)
+	}
+
+	if e := makeStackSizeEnv(env, "RUST_MIN_STACK", StackUnlimited); len(e) != 1 {
+		t.Fatalf("unlimited stack should not be passed (%v)", e)
+	}
+
+	e := makeStackSizeEnv(env, "RUST_MIN_STACK", 1024)
+	if e["RUST_MIN_STACK"] != "1024" || e["PATH"] != "/usr/bin" {
+		t.Fatalf("stack size should be passed (%v)", e)
+	}
+	if _, ok := env["RUST_MIN_STACK"]; ok {
+		t.Fatalf("env of the profile should not be modified (%v)", env)
+	}
+}
@@ -24,19 +24,38 @@ import(
 	"syscall"
 	"runtime"
 	"log"
+	"path/filepath"
+	"sync/atomic"
 )
 
 
 //
 type ResourceLimit struct {
-	CPU		uint64
+	CPUMs	uint64
 	AS		uint64
 	FSize	uint64
+	WallMs	uint64
+	Stdout	uint64	// bytes of outputs
+	Stderr	uint64
+	NOFile	uint64
+	NProc	uint64
+	MemLock	uint64
+	Stack	uint64
 }
 
 //
 var errorSequence = []byte{ 0x0d, 0x0e, 0x0a, 0x0d }
 
+// failures of the child process before exec. the status follows errorSequence
+type childExecError struct {
+	status		ExecutedStatus
+	err			error
+}
+
+func (e *childExecError) Error() string {
+	return e.err.Error()
+}
+
 
 //
 func (bm *BridgeMessage) managedExec(
@@ -63,17 +82,64 @@ func (bm *BridgeMessage) managedExec(
 	if err := bm.Pipes.Result.ToCloseOnExec(); err != nil {
 		return nil, err
 	}
+	if bm.Redirect != nil {
+		if err := setCloseOnExec(bm.Redirect.Stdin, true); err != nil {
+			return nil, err
+		}
+		if err := setCloseOnExec(bm.Redirect.Stdout, true); err != nil {
+			return nil, err
+		}
+	}
+
+	// take a snapshot of HOME to report changes of files
+	user_home_path := filepath.Join(bm.ChrootPath, bm.JailedUserHomePath)
+	var snapshot *fileSnapshot = nil
+	if bm.Message.Setting != nil && bm.Message.Setting.ReportFileChanges {
+		snapshot, err = takeFileSnapshot(user_home_path)
+		if err != nil { return nil, err }
+	}
+
+	// resources of all processes are controlled by the cgroup if the profile requested
+	var cgroup *execCgroup = nil
+	if isCgroupControlled(bm.Message.Profile) {
+		cgroup, err = newExecCgroup(bm.CgroupRootPath, rl)
+		if err != nil { return nil, err }
+		defer cgroup.remove()
+	}
+
+	// outputs are relayed to cut them at the limit. stdout is NOT relayed if it is connected to the peer
+	var relays []*outputRelay
+	var stdout_relay *outputRelay = nil
+	if bm.Redirect == nil {
+		stdout_relay, err = newOutputRelay(bm.Pipes.Stdout, rl.Stdout)
+		if err != nil { return nil, err }
+		relays = append(relays, stdout_relay)
+	}
+	stderr_relay, err := newOutputRelay(bm.Pipes.Stderr, rl.Stderr)
+	if err != nil {
+		if stdout_relay != nil { stdout_relay.pipe.Close() }
+		return nil, err
+	}
+	relays = append(relays, stderr_relay)
 
 	// fork process!
+	started_at := time.Now()
 	pid, err := fork()
 	if err != nil {
+		for _, r := range relays {
+			r.pipe.Close()
+		}
 		return nil, err;
 	}
 	if pid == 0 {
 		// !! call child process !!
 		log.Printf(">> managedExec || child\n")
 
-		bm.managedExecChild(rl, *error_pipe, args, envs, umask, stdin_file_path)
+		stdout_fd := -1
+		if stdout_relay != nil {
+			stdout_fd = stdout_relay.pipe.WriteFd
+		}
+		bm.managedExecChild(rl, cgroup, *error_pipe, args, envs, umask, stdin_file_path, stdout_fd, stderr_relay.pipe.WriteFd)
 		return nil, nil
 
 	} else {
@@ -85,11 +151,28 @@ func (bm *BridgeMessage) managedExec(
 			umountJail(bm.ChrootPath);
 		}()
 
+		// the process is killed if outputs exceeded limits
+		var output_exceeded int32 = 0
+		for _, r := range relays {
+			r.start(func() {
+				atomic.StoreInt32(&output_exceeded, 1)
+				if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+					log.Printf("Failed to kill a process(%d) which exceeded the output limit.\n", pid)
+				}
+			})
+		}
+		if stdout_relay == nil {
+			bm.Pipes.Stdout.Close()
+		}
+
 		//
-		bm.Pipes.Stdout.Close()
-		bm.Pipes.Stderr.Close()
 		bm.Pipes.Result.CloseRead()
 		error_pipe.CloseWrite()
+		if bm.Redirect != nil {
+			// the peer process can detect EOF when the child exits
+			syscall.Close(bm.Redirect.Stdin)
+			syscall.Close(bm.Redirect.Stdout)
+		}
 
 		//
 		process, err := os.FindProcess(pid)
@@ -106,25 +189,33 @@ func (bm *BridgeMessage) managedExec(
 
 		//
 		pass_kill_chan := make(chan bool)
-		go func(pass_kill_chan chan bool, rl *ResourceLimit, pid int) {
-			select {
-			case <-pass_kill_chan:
-				/* DO NOTHING */
-				log.Println("PASS")
-
-			case <-time.After(time.Duration(rl.CPU + 5) * time.Second):
-				log.Printf("Kill a sleeping process(%d).\n", pid)
-				if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
-					log.Printf("Failed to kill a sleeping process(%d).\n", pid)
-				}
-			}
-		}(pass_kill_chan, rl, pid)
+		killed_chan := make(chan ExecutedStatus, 1)	// receives the reason if the process was killed by the watchdog
+		peaks := &processPeaks{}
+		sample := func() (time.Duration, error) { return peaks.sampleProcess(pid) }
+		if cgroup != nil {
+			sample = func() (time.Duration, error) { return peaks.sampleCgroup(cgroup) }
+		}
+		go watchProcess(pass_kill_chan, killed_chan, rl, pid, sample)
 
 		//
 		select {
 		case ps := <-wait_pid_chan:
+			wall_time := float64(time.Since(started_at).Nanoseconds()) / 1e9
 			close(pass_kill_chan)
 
+			// all outputs must be passed before the result
+			for _, r := range relays {
+				r.wait()
+			}
+
+			// the watchdog may have killed the process just before the wait returned
+			var killed_status *ExecutedStatus = nil
+			select {
+			case s := <-killed_chan:
+				killed_status = &s
+			default:
+			}
+
 			// take status
 			usage, ok := ps.SysUsage().(*syscall.Rusage)
 			if !ok {
@@ -161,48 +252,106 @@ func (bm *BridgeMessage) managedExec(
 				return_code := wait_status.ExitStatus()
 
 				// CPU time
-				user_time := usage.Utime
-				system_time := usage.Stime
+				user_time := float64(usage.Utime.Nano()) / 1e9
+				system_time := float64(usage.Stime.Nano()) / 1e9
 
-				cpu_time := float32(user_time.Nano()) / 1e9 + float32(system_time.Nano()) / 1e9
+				cpu_time := user_time + system_time
 
 				// Memory usage
 				// usage.Maxrss -> Amount of memory usage (KB)
 				// TODO: fix it
 				memory := uint64(usage.Maxrss * 1024)
+				memory_usage := &memoryUsage{
+					peakVirtualBytes: atomic.LoadUint64(&peaks.virtualBytes),
+				}
+
+				// the cgroup also counts processes which have not been reaped
+				if cgroup != nil {
+					if t, err := cgroup.cpuTime(); err == nil {
+						cpu_time = float64(t.Nanoseconds()) / 1e9
+					}
+					if u, s, err := cgroup.cpuUserSystemTime(); err == nil {
+						user_time = float64(u.Nanoseconds()) / 1e9
+						system_time = float64(s.Nanoseconds()) / 1e9
+					}
+					if peak, ok := cgroup.peakMemoryBytes(); ok {
+						memory = peak
+					}
+					oom_kill_count, max_count := cgroup.memoryEvents()
+					memory_usage.isCgroupAccounted = true
+					memory_usage.isOOMKilled = oom_kill_count > 0
+					memory_usage.limitHitCount = max_count
+				}
+				memory_usage.peakBytes = memory
 
 				// take status
 				status := func() ExecutedStatus {
-					if signal != nil {
-						if *signal == syscall.SIGXCPU {
-							return CPULimit
-						}
+					if signal != nil && *signal == syscall.SIGXCPU {
+						return CPULimit
 					}
-					if cpu_time > float32(rl.CPU) {
+					if cpu_time * 1000 > float64(rl.CPUMs) {
 						return CPULimit
 					}
+					if killed_status != nil {
+						return *killed_status
+					}
+					if wall_time * 1000 >= float64(rl.WallMs) {
+						return WallTimeLimit
+					}
+					if atomic.LoadInt32(&output_exceeded) != 0 {
+						return OutputLimit
+					}
+					if isMemoryLimitExceeded(memory_usage, rl.AS, ps.Success()) {
+						return MemoryLimit
+					}
 
 					if ps.Success() {
 						return Passed
-					} else {
-						return Error
 					}
+					if signal != nil {
+						return statusFromSignal(*signal)
+					}
+					return Error
 				}()
 
 				// make result
-				return &ExecutedResult{
+				result := &ExecutedResult{
 					UsedCPUTimeSec: cpu_time,
+					UsedWallTimeSec: wall_time,
+					UsedUserTimeSec: user_time,
+					UsedSystemTimeSec: system_time,
 					UsedMemoryBytes: memory,
+					MajorPageFaults: uint64(usage.Majflt),
+					MinorPageFaults: uint64(usage.Minflt),
+					VoluntaryContextSwitches: uint64(usage.Nvcsw),
+					InvoluntaryContextSwitches: uint64(usage.Nivcsw),
+					StdoutBytes: stdout_relay.writtenBytes(),
+					StderrBytes: stderr_relay.writtenBytes(),
+					PeakProcessCount: atomic.LoadUint64(&peaks.processes),
+					PeakThreadCount: atomic.LoadUint64(&peaks.threads),
 					Signal: signal,
 					ReturnCode: return_code,
 					CommandLine: strings.Join(args, " "),
 					Status: status,
-				}, nil
+				}
+
+				// changes of files
+				if snapshot != nil {
+					after, err := takeFileSnapshot(user_home_path)
+					if err != nil {
+						log.Printf("failed to take a snapshot of %s (%v)\n", user_home_path, err)
+					} else {
+						result.FileChanges = diffFileSnapshots(snapshot, after)
+					}
+				}
+
+				return result, nil
 
 			} else {
 				// execution was failed
-				if bytes.Equal(error_buf[:len(errorSequence)], errorSequence) {
-					error_log := string(error_buf[4:error_len])
+				if error_len > len(errorSequence) && bytes.Equal(error_buf[:len(errorSequence)], errorSequence) {
+					failed_status := ExecutedStatus(error_buf[len(errorSequence)])
+					error_log := string(error_buf[len(errorSequence) + 1:error_len])
 					for {
 						size, err := syscall.Read(error_pipe.ReadFd, error_buf)
 						if err != nil || size == 0 {
@@ -212,6 +361,14 @@ func (bm *BridgeMessage) managedExec(
 						error_log += string(error_buf[:size])
 					}
 
+					// the command is wrong. others are failures of the system
+					if failed_status == InvalidCommand {
+						return &ExecutedResult{
+							CommandLine: strings.Join(args, " "),
+							Status: InvalidCommand,
+							SystemErrorMessage: error_log,
+						}, nil
+					}
 					return nil, errors.New(error_log)
 
 				} else {
@@ -220,7 +377,7 @@ func (bm *BridgeMessage) managedExec(
 				}
 			}
 
-		case <-time.After(time.Duration(rl.CPU * 2 + 10) * time.Second):
+		case <-time.After(time.Duration(rl.CPUMs * 2 + rl.WallMs) * time.Millisecond + 10 * time.Second):
 			// unexpected timeout
 			return nil, errors.New("Unexpected TLE...")
 		}
@@ -230,18 +387,27 @@ func (bm *BridgeMessage) managedExec(
 
 func (bm *BridgeMessage) managedExecChild(
 	rl					*ResourceLimit,
+	cgroup				*execCgroup,
 	error_pipe			Pipe/*close on exec*/,
 	args				[]string,
 	envs				map[string]string,
 	umask				int,
 	stdin_file_path		*string,
+	stdout_fd			int,		// -1 if stdout is connected to the peer
+	stderr_fd			int,
 ) {
 	// if called this function, child process is failed to execute
 	defer func() {
 		// mark failed result
 		syscall.Close(error_pipe.ReadFd)
 		syscall.Write(error_pipe.WriteFd, errorSequence)		// write error sequence
-		if r := recover(); r != nil {
+		r := recover()
+		failed_status := UnexpectedError
+		if e, ok := r.(*childExecError); ok {
+			failed_status = e.status
+		}
+		syscall.Write(error_pipe.WriteFd, []byte{ byte(failed_status) })	// write status
+		if r != nil {
 			if err, ok := r.(error); ok {
 				syscall.Write(error_pipe.WriteFd, []byte(err.Error()))	// write panic sentence
 			}
@@ -253,6 +419,13 @@ func (bm *BridgeMessage) managedExecChild(
 	}()
 
 
+	// processes can NOT leave the cgroup after privilege was dropped
+	if cgroup != nil {
+		if err := cgroup.enter(); err != nil {
+			panic(err)
+		}
+	}
+
 	// !!! ===================
 	// Drop privilege
 	// !! IMPORTANT !!
@@ -263,41 +436,21 @@ func (bm *BridgeMessage) managedExecChild(
 	// !!! ===================
 
 
-	log.Printf("==================================================\n")
-	out, err := exec.Command("/bin/ps", "aux").Output()
-	if err != nil {
-		log.Printf("error:: %s\n", err.Error())
-	} else {
-		log.Printf("passed:: \n%s\n", out)
-	}
-
-	log.Printf("==================================================\n")
-	out, err = exec.Command("/bin/ls", "-la", "/").Output()
-	if err != nil {
-		log.Printf("error:: %s\n", err.Error())
-	} else {
-		log.Printf("passed:: \n%s\n", out)
-	}
-
-	log.Printf("==================================================\n")
-	out, err = exec.Command("/bin/ls", "-laR", "/home").Output()
-	if err != nil {
-		log.Printf("error:: %s\n", err.Error())
-	} else {
-		log.Printf("passed:: \n%s\n", out)
-	}
-
 	log.Printf("== Managed: child           (%v)\n", args)
 	log.Printf("== Managed: envs            (%v)\n", envs)
-	log.Printf("== Managed: CPU(sec)        (%v)\n", rl.CPU)
+	log.Printf("== Managed: CPU(msec)       (%v)\n", rl.CPUMs)
+	log.Printf("== Managed: wall(msec)      (%v)\n", rl.WallMs)
 	log.Printf("== Managed: memory(byte)    (%v)\n", rl.AS)
 	log.Printf("== Managed: fsize           (%v)\n", rl.FSize)
+	log.Printf("== Managed: stdout/stderr   (%v/%v)\n", rl.Stdout, rl.Stderr)
+	log.Printf("== Managed: nofile/nproc    (%v/%v)\n", rl.NOFile, rl.NProc)
+	log.Printf("== Managed: stack(byte)     (%v)\n", rl.Stack)
 
 	// limit(1/2)
  	setLimit(C.RLIMIT_CORE, 0)			// Process can NOT create CORE file
- 	setLimit(C.RLIMIT_NOFILE, 512)		// Process can open 512 files
-	setLimit(C.RLIMIT_NPROC, 30)		// Process can create processes to 30
- 	setLimit(C.RLIMIT_MEMLOCK, 1024)	// Process can lock 1024 Bytes by mlock(2)
+ 	setLimit(C.RLIMIT_NOFILE, rl.NOFile)		// Process can open NOFile files
+	setLimit(C.RLIMIT_NPROC, rl.NProc)			// Process can create processes to NProc
+ 	setLimit(C.RLIMIT_MEMLOCK, rl.MemLock)		// Process can lock MemLock Bytes by mlock(2)
 
 	//
 	syscall.Umask(umask)
@@ -311,12 +464,12 @@ func (bm *BridgeMessage) managedExecChild(
 
 	//
 	if len(args) < 1 {
-		panic(errors.New("args must contain at least one element"))
+		panic(&childExecError{ InvalidCommand, errors.New("args must contain at least one element") })
 	}
 	command := args[0]	// args[0] is program name
 	exec_path, err := exec.LookPath(command)
 	if err != nil {
-		panic(err)
+		panic(&childExecError{ InvalidCommand, err })
 	}
 
 	//
@@ -330,6 +483,12 @@ func (bm *BridgeMessage) managedExecChild(
 	// close unused pipe
 	if err := bm.Pipes.Result.Close(); err != nil { panic(err) }
 
+	// redirect stdin/stdout to the peer process
+	if bm.Redirect != nil {
+		if err := syscall.Dup2(bm.Redirect.Stdin, 0); err != nil { panic(err) }
+		if err := syscall.Dup2(bm.Redirect.Stdout, 1); err != nil { panic(err) }
+	}
+
 	// redirect stdin
 	if stdin_file_path != nil {
 		log.Printf("============= stdin (%v)\n", *stdin_file_path)
@@ -343,24 +502,29 @@ func (bm *BridgeMessage) managedExecChild(
 
 	// redirect stdout
 	if err := bm.Pipes.Stdout.CloseRead(); err != nil { panic(err) }
-	if err := syscall.Dup2(bm.Pipes.Stdout.WriteFd, 1); err != nil { panic(err) }
+	if stdout_fd != -1 {
+		if err := syscall.Dup2(stdout_fd, 1); err != nil { panic(err) }
+	}
 	if err := bm.Pipes.Stdout.CloseWrite(); err != nil { panic(err) }
 
 	// redirect stderr
 	if err := bm.Pipes.Stderr.CloseRead(); err != nil { panic(err) }
-	if err := syscall.Dup2(bm.Pipes.Stderr.WriteFd, 2); err != nil { panic(err) }
+	if err := syscall.Dup2(stderr_fd, 2); err != nil { panic(err) }
 	if err := bm.Pipes.Stderr.CloseWrite(); err != nil { panic(err) }
 
 	// limit(2/2)
-	setLimitWithMarginSec(C.RLIMIT_CPU, rl.CPU)		// CPU can be used only cpu_limit_time(sec)
- 	setLimit(C.RLIMIT_AS, rl.AS)					// Memory can be used only memory_limit_bytes [be careful!]
+	setLimitWithMarginSec(C.RLIMIT_CPU, (rl.CPUMs + 999) / 1000)	// backstop of the watchdog, rounded up to seconds
+ 	if cgroup == nil {
+		setLimit(C.RLIMIT_AS, rl.AS)				// Memory can be used only memory_limit_bytes [be careful!]
+	}
  	setLimit(C.RLIMIT_FSIZE, rl.FSize)				// Process can writes a file only FSize Bytes
+	setLimit(C.RLIMIT_STACK, rl.Stack)				// Stack can grow up to Stack Bytes
 
 	// ==========
 	// exec!!
 	err = syscall.Exec(exec_path, args, env_list);
 
-	panic(errors.New(fmt.Sprintf("UNREACHABLE!! managedExecChild / failed to Exec. Error => " + err.Error())))
+	panic(&childExecError{ InvalidCommand, errors.New(fmt.Sprintf("UNREACHABLE!! managedExecChild / failed to Exec. Error => " + err.Error())) })
 }
 
 
@@ -0,0 +1,100 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import(
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+)
+
+
+// observations to decide whether the memory limit was exceeded
+type memoryUsage struct {
+	peakBytes			uint64	// peak of RSS, or of the cgroup
+	peakVirtualBytes	uint64	// sampled by the watchdog, 0 if unknown
+	isCgroupAccounted	bool	// memory was limited and accounted by the cgroup
+	isOOMKilled			bool	// a process in the cgroup was killed by OOM killer
+	limitHitCount		uint64	// the number of times the usage of the cgroup reached memory.max
+}
+
+// usage which is more than this ratio of the limit is "near the limit"
+const memoryLimitNearRatio = 0.9
+
+// allocations which exceed the limit are never observed directly. they appear as
+// SIGSEGV/SIGABRT/SIGBUS or a non-zero exit code (e.g. std::bad_alloc, MemoryError, OutOfMemoryError).
+// so heuristics below are applied in order
+//  1. OOM killer killed a process in the cgroup => exceeded
+//  2. a process which exited successfully => NOT exceeded
+//  3. the cgroup reported that the usage reached memory.max => exceeded
+//  4. peak usage (RSS or the cgroup) was near the limit => exceeded
+//  5. the cgroup accounted memory => NOT exceeded
+//  6. peak virtual memory was near the limit => exceeded
+//  7. otherwise => NOT exceeded (a failed huge allocation which didn't raise the peak is reported as Error)
+// runtimes (e.g. JVM, Go) reserve a large address space up front, so virtual memory is used
+// only when memory was limited by RLIMIT_AS
+func isMemoryLimitExceeded(usage *memoryUsage, limit_bytes uint64, is_succeeded bool) bool {
+	if usage.isOOMKilled {
+		return true
+	}
+	if is_succeeded || limit_bytes == 0 {
+		return false
+	}
+
+	if usage.limitHitCount > 0 {
+		return true
+	}
+
+	near_bytes := uint64(float64(limit_bytes) * memoryLimitNearRatio)
+	if usage.peakBytes >= near_bytes {
+		return true
+	}
+	if usage.isCgroupAccounted {
+		return false
+	}
+
+	return usage.peakVirtualBytes >= near_bytes
+}
+
+
+// peak of the virtual memory size of the process
+func readProcessPeakVirtualBytes(pid int) (uint64, error) {
+	status, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	return parseProcStatusBytes(status, "VmPeak")
+}
+
+// e.g. "VmPeak:\t   12345 kB"
+func parseProcStatusBytes(status []byte, key string) (uint64, error) {
+	prefix := []byte(key + ":")
+	for _, line := range bytes.Split(status, []byte("\n")) {
+		if !bytes.HasPrefix(line, prefix) {
+			continue
+		}
+
+		fields := bytes.Fields(line[len(prefix):])
+		if len(fields) != 2 || string(fields[1]) != "kB" {
+			return 0, errors.New(fmt.Sprintf("invalid status (%s)", line))
+		}
+		kb, err := strconv.ParseUint(string(fields[0]), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return kb * 1024, nil
+	}
+
+	return 0, errors.New(fmt.Sprintf("key %s was not found", key))
+}
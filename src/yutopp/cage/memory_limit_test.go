@@ -0,0 +1,57 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import (
+	"testing"
+)
+
+
+func TestUnitIsMemoryLimitExceeded(t *testing.T) {
+	const limit = 100 * 1024 * 1024
+
+	cases := []struct {
+		usage			memoryUsage
+		isSucceeded		bool
+		expected		bool
+	}{
+		{ memoryUsage{ isOOMKilled: true }, true, true },
+		{ memoryUsage{ peakBytes: limit }, true, false },
+		{ memoryUsage{ limitHitCount: 1 }, false, true },
+		{ memoryUsage{ peakBytes: limit / 100 * 95 }, false, true },
+		{ memoryUsage{ peakVirtualBytes: limit / 100 * 95 }, false, true },
+		{ memoryUsage{ peakBytes: limit / 2, peakVirtualBytes: limit / 2 }, false, false },
+		// address space which was reserved up front is ignored if the cgroup accounted memory
+		{ memoryUsage{ peakBytes: limit / 2, peakVirtualBytes: limit * 4, isCgroupAccounted: true }, false, false },
+		{ memoryUsage{ peakBytes: limit / 100 * 95, peakVirtualBytes: limit * 4, isCgroupAccounted: true }, false, true },
+		{ memoryUsage{ peakVirtualBytes: limit * 4, isCgroupAccounted: true, limitHitCount: 1 }, false, true },
+		{ memoryUsage{ peakVirtualBytes: limit * 4, isCgroupAccounted: true, isOOMKilled: true }, false, true },
+	}
+	for i, c := range cases {
+		if actual := isMemoryLimitExceeded(&c.usage, limit, c.isSucceeded); actual != c.expected {
+			t.Fatalf("case %d: expected %t (but %t)", i, c.expected, actual)
+		}
+	}
+}
+
+func TestUnitParseProcStatusBytes(t *testing.T) {
+	status := []byte("Name:\tprog\nVmPeak:\t   12345 kB\nVmSize:\t   12000 kB\n")
+
+	v, err := parseProcStatusBytes(status, "VmPeak")
+	if err != nil { t.Fatalf("%v", err) }
+	if v != 12345 * 1024 {
+		t.Fatalf("VmPeak should be 12345 kB (but %d)", v)
+	}
+
+	if _, err := parseProcStatusBytes(status, "VmHWM"); err == nil {
+		t.Fatalf("unknown key should be rejected")
+	}
+}
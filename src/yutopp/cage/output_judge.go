@@ -0,0 +1,230 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+package torigoya
+
+import (
+	"errors"
+	"fmt"
+	"bytes"
+	"math"
+	"strconv"
+)
+
+
+// ========================================
+type JudgeStatus	int
+const (
+	Accepted		= JudgeStatus(1)
+	WrongAnswer		= JudgeStatus(2)
+	JudgeFailed		= JudgeStatus(3)	// judge program itself was failed
+	PartiallyAccepted	= JudgeStatus(4)	// the checker gave a partial score
+)
+
+//
+type JudgeVerdict struct {
+	Status		JudgeStatus
+	Message		string			// short excerpt of the difference
+	Score		float64			// ratio of points in [0, 1], 1 if accepted
+}
+
+func (v *JudgeVerdict) ToTuple() []interface{} {
+	return []interface{}{ v.Status, v.Message, v.Score }
+}
+
+
+// ========================================
+type ComparatorKind	int
+const (
+	ExactComparator					= ComparatorKind(0)
+	WhitespaceInsensitiveComparator	= ComparatorKind(1)		// compares whitespace separated fields line by line
+	TokenComparator					= ComparatorKind(2)		// compares whitespace separated tokens, ignoring line structure
+	FloatComparator					= ComparatorKind(3)		// TokenComparator + numbers are compared with tolerance
+)
+
+//
+type OutputComparator struct {
+	Kind			ComparatorKind
+	AbsoluteError	float64
+	RelativeError	float64
+}
+
+// an excerpt longer than this will be truncated
+const judgeExcerptLength = 64
+
+func (c *OutputComparator) Compare(expected, actual []byte) *JudgeVerdict {
+	kind := ExactComparator
+	if c != nil { kind = c.Kind }
+
+	message := func() string {
+		switch kind {
+		case WhitespaceInsensitiveComparator:
+			return compareLineFields(expected, actual)
+		case TokenComparator:
+			return compareTokens(expected, actual, nil)
+		case FloatComparator:
+			return compareTokens(expected, actual, c)
+		default:
+			return compareExact(expected, actual)
+		}
+	}()
+
+	if message != "" {
+		return &JudgeVerdict{
+			Status: WrongAnswer,
+			Message: message,
+		}
+	}
+
+	return &JudgeVerdict{
+		Status: Accepted,
+		Score: 1,
+	}
+}
+
+func compareExact(expected, actual []byte) string {
+	if bytes.Equal(expected, actual) {
+		return ""
+	}
+
+	expected_lines := bytes.Split(expected, []byte("\n"))
+	actual_lines := bytes.Split(actual, []byte("\n"))
+	for i := 0; i < len(expected_lines) || i < len(actual_lines); i++ {
+		e, a := lineAt(expected_lines, i), lineAt(actual_lines, i)
+		if e == nil || a == nil || !bytes.Equal(e, a) {
+			return makeDifferenceMessage("line", i + 1, e, a)
+		}
+	}
+
+	return "output differs"
+}
+
+func compareLineFields(expected, actual []byte) string {
+	expected_lines := trimTrailingEmptyLines(bytes.Split(expected, []byte("\n")))
+	actual_lines := trimTrailingEmptyLines(bytes.Split(actual, []byte("\n")))
+
+	for i := 0; i < len(expected_lines) || i < len(actual_lines); i++ {
+		e, a := lineAt(expected_lines, i), lineAt(actual_lines, i)
+		if e == nil || a == nil || !equalFields(bytes.Fields(e), bytes.Fields(a)) {
+			return makeDifferenceMessage("line", i + 1, e, a)
+		}
+	}
+
+	return ""
+}
+
+// if tolerance is nil, tokens are compared exactly
+func compareTokens(expected, actual []byte, tolerance *OutputComparator) string {
+	expected_tokens := bytes.Fields(expected)
+	actual_tokens := bytes.Fields(actual)
+
+	for i := 0; i < len(expected_tokens) || i < len(actual_tokens); i++ {
+		e, a := lineAt(expected_tokens, i), lineAt(actual_tokens, i)
+		if e == nil || a == nil {
+			return makeDifferenceMessage("token", i + 1, e, a)
+		}
+
+		if tolerance != nil {
+			if equal, err := tolerance.equalAsFloat(e, a); err == nil {
+				if !equal {
+					return makeDifferenceMessage("token", i + 1, e, a)
+				}
+				continue
+			}
+		}
+
+		if !bytes.Equal(e, a) {
+			return makeDifferenceMessage("token", i + 1, e, a)
+		}
+	}
+
+	return ""
+}
+
+// returns error if the expected token is not a number
+func (c *OutputComparator) equalAsFloat(expected_token, actual_token []byte) (bool, error) {
+	expected, err := strconv.ParseFloat(string(expected_token), 64)
+	if err != nil { return false, err }
+
+	actual, err := strconv.ParseFloat(string(actual_token), 64)
+	if err != nil { return false, nil }
+
+	if math.IsNaN(expected) || math.IsNaN(actual) {
+		return math.IsNaN(expected) && math.IsNaN(actual), nil
+	}
+
+	diff := math.Abs(expected - actual)
+	if diff <= c.AbsoluteError {
+		return true, nil
+	}
+	if diff <= c.RelativeError * math.Abs(expected) {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func equalFields(a, b [][]byte) bool {
+	if len(a) != len(b) { return false }
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) { return false }
+	}
+	return true
+}
+
+func lineAt(lines [][]byte, index int) []byte {
+	if index < len(lines) { return lines[index] }
+	return nil
+}
+
+func trimTrailingEmptyLines(lines [][]byte) [][]byte {
+	for len(lines) > 0 && len(bytes.TrimSpace(lines[len(lines)-1])) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func makeDifferenceMessage(unit string, position int, expected, actual []byte) string {
+	excerpt := func(b []byte) string {
+		if b == nil { return "(EOF)" }
+		if len(b) > judgeExcerptLength {
+			return strconv.Quote(string(b[:judgeExcerptLength])) + "..."
+		}
+		return strconv.Quote(string(b))
+	}
+
+	return fmt.Sprintf("%s %d: expected %s but found %s", unit, position, excerpt(expected), excerpt(actual))
+}
+
+
+// ========================================
+func MakeOutputComparatorFromTuple(tupled interface{}) (*OutputComparator, error) {
+	if tupled == nil { return nil, nil }
+	interface_array, ok := tupled.([]interface{})
+	if !ok { return nil, errors.New("OutputComparator::invalid data(total)") }
+	if len(interface_array) != 3 { return nil, errors.New("OutputComparator::invalid data(num of lement)") }
+
+	//
+	kind, ok := readUInt(interface_array[0])
+	if !ok { return nil, errors.New("OutputComparator::invalid data(0)") }
+	if ComparatorKind(kind) > FloatComparator { return nil, errors.New("OutputComparator::invalid data(0) unknown kind") }
+
+	//
+	absolute_error, ok := readFloat(interface_array[1])
+	if !ok { return nil, errors.New("OutputComparator::invalid data(1)") }
+
+	//
+	relative_error, ok := readFloat(interface_array[2])
+	if !ok { return nil, errors.New("OutputComparator::invalid data(2)") }
+
+	return &OutputComparator{
+		Kind: ComparatorKind(kind),
+		AbsoluteError: absolute_error,
+		RelativeError: relative_error,
+	}, nil
+}
@@ -0,0 +1,44 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+package torigoya
+
+import (
+	"testing"
+)
+
+
+func TestUnitOutputComparator(t *testing.T) {
+	cases := []struct {
+		comparator			*OutputComparator
+		expected, actual	string
+		status				JudgeStatus
+	}{
+		{ nil, "1 2\n3\n", "1 2\n3\n", Accepted },
+		{ nil, "1 2\n3\n", "1 2\n3", WrongAnswer },
+		{ &OutputComparator{ Kind: WhitespaceInsensitiveComparator }, "1 2\n3\n", "1  2 \n3\n\n", Accepted },
+		{ &OutputComparator{ Kind: WhitespaceInsensitiveComparator }, "1 2\n3\n", "1\n2 3\n", WrongAnswer },
+		{ &OutputComparator{ Kind: TokenComparator }, "1 2\n3\n", "1\n2 3", Accepted },
+		{ &OutputComparator{ Kind: TokenComparator }, "1 2\n3\n", "1 2", WrongAnswer },
+		{ &OutputComparator{ Kind: FloatComparator, AbsoluteError: 1e-6 }, "0.5 abc\n", "0.5000001 abc\n", Accepted },
+		{ &OutputComparator{ Kind: FloatComparator, AbsoluteError: 1e-6 }, "0.5 abc\n", "0.5001 abc\n", WrongAnswer },
+		{ &OutputComparator{ Kind: FloatComparator, RelativeError: 1e-3 }, "1000000\n", "1000500\n", Accepted },
+		{ &OutputComparator{ Kind: FloatComparator, RelativeError: 1e-3 }, "1000000\n", "1002000\n", WrongAnswer },
+		{ &OutputComparator{ Kind: FloatComparator, AbsoluteError: 1 }, "abc\n", "abd\n", WrongAnswer },
+	}
+
+	for i, c := range cases {
+		verdict := c.comparator.Compare([]byte(c.expected), []byte(c.actual))
+		if verdict.Status != c.status {
+			t.Fatalf("case %d: status should be %v(but %v / %s)", i, c.status, verdict.Status, verdict.Message)
+		}
+		if verdict.Status == WrongAnswer && verdict.Message == "" {
+			t.Fatalf("case %d: message should not be empty", i)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import(
+	"fmt"
+	"log"
+	"syscall"
+	"sync/atomic"
+	"time"
+)
+
+
+// appended to the stream when it was cut
+const outputTruncatedMarkerFormat = "\n[torigoya: output was truncated because it exceeded %d bytes]\n"
+
+// relays may be blocked by processes which inherited the pipe (e.g. daemons)
+const outputRelayTimeout = 1 * time.Second
+
+
+// ========================================
+// outputs of the program are passed through this to count them
+type outputRelay struct {
+	pipe				*Pipe		// the program writes to this pipe
+	dst					*Pipe		// the server reads from this pipe
+	limit				uint64		// 0 means "unlimited"
+	written				uint64		// bytes written by the program, accessed atomically
+	done				chan bool
+}
+
+func newOutputRelay(dst *Pipe, limit uint64) (*outputRelay, error) {
+	pipe, err := makePipeCloseOnExec()
+	if err != nil { return nil, err }
+
+	return &outputRelay{
+		pipe: pipe,
+		dst: dst,
+		limit: limit,
+		done: make(chan bool),
+	}, nil
+}
+
+// on_exceeded is called once when the output exceeded the limit
+func (r *outputRelay) start(on_exceeded func()) {
+	r.pipe.CloseWrite()
+	r.dst.CloseRead()
+
+	go func() {
+		defer close(r.done)
+		defer r.pipe.CloseRead()
+		defer r.dst.CloseWrite()
+
+		written, is_cut := relayOutput(r.pipe.ReadFd, r.dst.WriteFd, r.limit)
+		atomic.StoreUint64(&r.written, written)
+		if is_cut {
+			on_exceeded()
+		}
+	}()
+}
+
+func (r *outputRelay) writtenBytes() uint64 {
+	if r == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&r.written)
+}
+
+func (r *outputRelay) wait() {
+	select {
+	case <-r.done:
+	case <-time.After(outputRelayTimeout):
+		log.Printf("output relay was not finished\n")
+	}
+}
+
+
+// returns bytes which were read from src_fd, and true if the output was cut
+func relayOutput(src_fd int, dst_fd int, limit uint64) (uint64, bool) {
+	buffer := make([]byte, ReadLength)
+	var written uint64 = 0
+
+	for {
+		size, err := syscall.Read(src_fd, buffer)
+		if err == syscall.EINTR { continue }
+		if err != nil || size <= 0 {
+			return written, false
+		}
+
+		if limit != 0 && written + uint64(size) > limit {
+			writeAllToFd(dst_fd, buffer[:limit - written])
+			writeAllToFd(dst_fd, []byte(fmt.Sprintf(outputTruncatedMarkerFormat, limit)))
+			return written + uint64(size), true
+		}
+
+		// the server doesn't read outputs anymore
+		if err := writeAllToFd(dst_fd, buffer[:size]); err != nil {
+			return written + uint64(size), false
+		}
+		written += uint64(size)
+	}
+}
+
+func writeAllToFd(fd int, buf []byte) error {
+	for len(buf) > 0 {
+		n, err := syscall.Write(fd, buf)
+		if err == syscall.EINTR { continue }
+		if err != nil {
+			return err
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
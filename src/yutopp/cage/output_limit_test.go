@@ -0,0 +1,50 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import (
+	"testing"
+	"fmt"
+)
+
+
+func relayOutputForTest(t *testing.T, data string, limit uint64) (string, uint64, bool) {
+	src, err := makePipe()
+	if err != nil { t.Fatalf("%v", err) }
+	defer src.Close()
+	dst, err := makePipe()
+	if err != nil { t.Fatalf("%v", err) }
+	defer dst.Close()
+
+	if err := writeAllToFd(src.WriteFd, []byte(data)); err != nil { t.Fatalf("%v", err) }
+	src.CloseWrite()
+
+	written, is_cut := relayOutput(src.ReadFd, dst.WriteFd, limit)
+	dst.CloseWrite()
+
+	relayed, err := readPipe(dst.ReadFd)
+	if err != nil { t.Fatalf("%v", err) }
+	return string(relayed), written, is_cut
+}
+
+func TestUnitRelayOutput(t *testing.T) {
+	if relayed, written, is_cut := relayOutputForTest(t, "0123456789", 0); is_cut || relayed != "0123456789" || written != 10 {
+		t.Fatalf("unlimited output should be passed (%s, %d, %t)", relayed, written, is_cut)
+	}
+	if relayed, written, is_cut := relayOutputForTest(t, "0123456789", 10); is_cut || relayed != "0123456789" || written != 10 {
+		t.Fatalf("output within the limit should be passed (%s, %d, %t)", relayed, written, is_cut)
+	}
+
+	expected := "01234" + fmt.Sprintf(outputTruncatedMarkerFormat, 5)
+	if relayed, written, is_cut := relayOutputForTest(t, "0123456789", 5); !is_cut || relayed != expected || written != 10 {
+		t.Fatalf("output should be cut (%s, %d, %t)", relayed, written, is_cut)
+	}
+}
@@ -29,14 +29,14 @@ type DebPackageUpdater struct {
 
 func (u *DebPackageUpdater) Update() error {
 	// update packages for torigoya
-	out, err := exec.Command("sudo", "apt-get", "update", "-o", "Dir::Etc::sourcelist=", u.SourceListPath, "-o", "Dir::Etc::sourceparts=", "-", "-o", "APT::Get::List-Cleanup=", "0").CombinedOutput()
+	out, err := commandCombinedOutput(exec.Command("sudo", "apt-get", "update", "-o", "Dir::Etc::sourcelist=", u.SourceListPath, "-o", "Dir::Etc::sourceparts=", "-", "-o", "APT::Get::List-Cleanup=", "0"))
 	log.Printf("DebPackageUpdater apt-get update : %s\n", out)
 	if err != nil {
 		return errors.New("DebPackageUpdater error: " + err.Error())
 	}
 
 	// search packages for torigoya
-	out, err = exec.Command("apt-cache", "search", "torigoya-*").Output()
+	out, err = commandOutput(exec.Command("apt-cache", "search", "torigoya-*"))
 	if err != nil {
 		return errors.New("DebPackageUpdater error: Couldn't search packages for torigoya")
 	}
@@ -70,7 +70,7 @@ func (u *DebPackageUpdater) Update() error {
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 
-		if err := cmd.Run(); err != nil {
+		if err := runCommand(cmd); err != nil {
 			m := fmt.Sprintf("DebPackageUpdater error: on installing [%s]\n", p)
 			log.Print(m)
 			return errors.New(m)
@@ -78,7 +78,7 @@ func (u *DebPackageUpdater) Update() error {
 	}
 
 	log.Printf("DebPackageUpdater info: try to upgrade: %v\n", formatted_packages)
-	if out, err := exec.Command("sudo", append([]string{"apt-get", "upgrade", "-y", "--force-yes"}, formatted_packages...)...).CombinedOutput(); err != nil {
+	if out, err := commandCombinedOutput(exec.Command("sudo", append([]string{"apt-get", "upgrade", "-y", "--force-yes"}, formatted_packages...)...)); err != nil {
 		m := fmt.Sprintf("DebPackageUpdater error: on upgrading [%s]\n", out)
 		log.Print(m)
 		return errors.New(m)
@@ -78,6 +78,17 @@ func (p *Pipe) ToCloseOnExec() (error) {
 	return nil
 }
 
+func setCloseOnExec(fd int, enabled bool) error {
+	flag := 0
+	if enabled { flag = syscall.FD_CLOEXEC }
+
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_SETFD, uintptr(flag)); errno != 0 {
+		return errors.New(fmt.Sprintf("Failed setCloseOnExec(%d): %d", fd, errno))
+	}
+
+	return nil
+}
+
 // ================================================================================
 
 func makePipe() (*Pipe, error) {
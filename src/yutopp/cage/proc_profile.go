@@ -52,6 +52,22 @@ type PhaseDetail struct {
 	Env						map[string]string
 	AllowedCommandLine		map[string]SelectableCommand `json:"allowed_command_line"`
 	FixedCommandLine		[][]string `json:"fixed_command_line"`
+	Limits					*PhaseLimits `json:"limits"`	// optional
+	StackSizeEnv			string `json:"stack_size_env"`	// optional, the stack limit(bytes) is passed by this env (e.g. RUST_MIN_STACK)
+}
+
+// limits of the phase, or maximums of the server. 0 means "not specified"
+type PhaseLimits struct {
+	CpuTimeMs			uint64 `json:"cpu_time_ms" yaml:"cpu_time_ms"`
+	MemoryBytes			uint64 `json:"memory_bytes" yaml:"memory_bytes"`
+	WallTimeMs			uint64 `json:"wall_time_ms" yaml:"wall_time_ms"`
+	FileSizeBytes		uint64 `json:"file_size_bytes" yaml:"file_size_bytes"`
+	StdoutBytes			uint64 `json:"stdout_bytes" yaml:"stdout_bytes"`
+	StderrBytes			uint64 `json:"stderr_bytes" yaml:"stderr_bytes"`
+	OpenFiles			uint64 `json:"open_files" yaml:"open_files"`
+	Processes			uint64 `json:"processes" yaml:"processes"`
+	LockedMemoryBytes	uint64 `json:"locked_memory_bytes" yaml:"locked_memory_bytes"`
+	StackBytes			uint64 `json:"stack_bytes" yaml:"stack_bytes"`		// the stack of the ticket is also bounded by this of the profile
 }
 
 func (pd *PhaseDetail) MakeCompleteArgs(
@@ -141,6 +157,7 @@ type ProcProfile struct {
 	Version						string
 	IsBuildRequired				bool `json:"is_build_required"`
 	IsLinkIndependent			bool `json:"is_link_independent"`
+	ResourceControl				string `json:"resource_control"`		// optional, "rlimit"(default) or "cgroup"
 
 	Source, Compile, Link, Run	PhaseDetail
 }
@@ -321,5 +338,5 @@ func (pt *ProcConfigTable) UpdateFromWeb(address string, base_path string) error
 	// -d: target dir
 	cmd := exec.Command("unzip", "-o", tmp_file.Name(), "-d", "files")
 
-	return cmd.Run()
+	return runCommand(cmd)
 }
@@ -24,7 +24,7 @@ func killUserProcess(
 	signals []string,
 ) error {
 	for _, signal := range signals {
-		err := exec.Command("pkill", "-", signal, "-u", user_name).Run()
+		err := runCommand(exec.Command("pkill", "-", signal, "-u", user_name))
 		if err != nil {
 			return err
 		}
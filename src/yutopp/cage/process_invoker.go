@@ -11,11 +11,14 @@
 package torigoya
 
 import(
+	"bytes"
 	"log"
 
 	"time"
 	"errors"
 	"os"
+	"os/exec"
+	"sync"
 	"syscall"
 	"path/filepath"
 )
@@ -59,6 +62,11 @@ func invokeProcessClonerBase(
 	output_stream	chan<-*StreamOutput,
 	debug_tag		string,
 ) (*ExecutedResult, error) {
+	// fds to redirect are owned by this function
+	if bm != nil && bm.Redirect != nil {
+		defer bm.Redirect.close()
+	}
+
 	// pipe for
 	stdout_pipe, err := makePipeNonBlocking()
 	if err != nil { return nil, err }
@@ -103,7 +111,7 @@ func invokeProcessClonerBase(
 	// Invoke Cloner
 	cloner_path := filepath.Join(cloner_dir, cloner_name)
 	log.Printf("%s: Cloner path: %s", debug_tag, cloner_path)
-	process, err := os.StartProcess(cloner_path, args, &attr)
+	process, err := startProcessWithRedirect(cloner_path, args, &attr, bm.Redirect)
 	if err != nil {
 		return nil, err
 	}
@@ -237,6 +245,72 @@ func invokeProcessClonerBase(
 	}
 }
 
+// redirected fds are created as close-on-exec to prevent leaking them into other processes,
+// so they are inherited only by this process and then closed.
+// EVERY process of the server must be started under this lock, otherwise it can inherit redirected fds
+// while they are inheritable and keep them open (EOF/SIGPIPE of the interaction would be delayed)
+var redirectSpawnMutex sync.RWMutex
+
+func startCommand(cmd *exec.Cmd) error {
+	redirectSpawnMutex.RLock()
+	defer redirectSpawnMutex.RUnlock()
+
+	return cmd.Start()
+}
+
+// same as cmd.Run()
+func runCommand(cmd *exec.Cmd) error {
+	if err := startCommand(cmd); err != nil {
+		return err
+	}
+	return cmd.Wait()
+}
+
+// same as cmd.Output()
+func commandOutput(cmd *exec.Cmd) ([]byte, error) {
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	err := runCommand(cmd)
+	return stdout.Bytes(), err
+}
+
+// same as cmd.CombinedOutput()
+func commandCombinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := runCommand(cmd)
+	return output.Bytes(), err
+}
+
+func startProcessWithRedirect(
+	path			string,
+	args			[]string,
+	attr			*os.ProcAttr,
+	redirect		*RedirectFds,
+) (*os.Process, error) {
+	if redirect == nil {
+		redirectSpawnMutex.RLock()
+		defer redirectSpawnMutex.RUnlock()
+
+		return os.StartProcess(path, args, attr)
+	}
+
+	redirectSpawnMutex.Lock()
+	defer redirectSpawnMutex.Unlock()
+
+	defer redirect.close()
+	for _, fd := range []int{ redirect.Stdin, redirect.Stdout } {
+		if err := setCloseOnExec(fd, false); err != nil {
+			return nil, err
+		}
+	}
+
+	return os.StartProcess(path, args, attr)
+}
+
 func readPipeAsync(
 	fd int,
 	cs chan<-error,
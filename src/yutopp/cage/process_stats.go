@@ -0,0 +1,163 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import(
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+
+// peaks which are sampled by the watchdog. all fields are accessed atomically, 0 if unknown
+type processPeaks struct {
+	virtualBytes		uint64
+	processes			uint64
+	threads				uint64
+}
+
+func (p *processPeaks) storeMax(field *uint64, v uint64) {
+	if v > atomic.LoadUint64(field) {
+		atomic.StoreUint64(field, v)
+	}
+}
+
+// the process is NOT controlled by a cgroup. returns CPU time of the process tree
+func (p *processPeaks) sampleProcess(pid int) (time.Duration, error) {
+	// VmPeak is reset by exec, so the latest value is the peak of the program
+	if peak, err := readProcessPeakVirtualBytes(pid); err == nil {
+		atomic.StoreUint64(&p.virtualBytes, peak)
+	}
+
+	tree, err := readProcessTree(pid)
+	if err != nil {
+		return 0, err
+	}
+	p.storeMax(&p.processes, tree.processes)
+	p.storeMax(&p.threads, tree.threads)
+
+	return tree.cpuTime, nil
+}
+
+// virtual memory is not limited by the cgroup, so it is not sampled. returns CPU time of the cgroup
+func (p *processPeaks) sampleCgroup(cgroup *execCgroup) (time.Duration, error) {
+	if processes, threads, err := cgroup.processCount(); err == nil {
+		p.storeMax(&p.processes, processes)
+		p.storeMax(&p.threads, threads)
+	}
+
+	return cgroup.cpuTime()
+}
+
+
+// totals of the process and its descendants
+type processTree struct {
+	processes			uint64
+	threads				uint64
+	cpuTime				time.Duration	// descendants which have exited are counted only if they were waited by a process in the tree
+}
+
+// /proc is scanned once, because it is called by the watchdog frequently
+func readProcessTree(root_pid int) (*processTree, error) {
+	stats, err := readProcessTreeStats(root_pid)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &processTree{}
+	for _, stat := range stats {
+		_, num_threads, err := parseProcStatParentAndThreads(stat)
+		if err != nil { continue }
+		cpu_time, err := parseProcStatCPUTime(stat, clockTicksPerSec)
+		if err != nil { continue }
+
+		tree.processes++
+		tree.threads += num_threads
+		tree.cpuTime += cpu_time
+	}
+
+	return tree, nil
+}
+
+// /proc/<pid>/stat of the process and its descendants
+func readProcessTreeStats(root_pid int) ([][]byte, error) {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	parents := make(map[int]int)
+	stats := make(map[int][]byte)
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil { continue }
+
+		// the process may have exited already
+		stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil { continue }
+		ppid, _, err := parseProcStatParentAndThreads(stat)
+		if err != nil { continue }
+
+		parents[pid] = ppid
+		stats[pid] = stat
+	}
+	if _, ok := parents[root_pid]; !ok {
+		return nil, errors.New(fmt.Sprintf("process %d was not found", root_pid))
+	}
+
+	tree_stats := make([][]byte, 0)
+	for pid := range parents {
+		if isDescendantProcess(parents, pid, root_pid) {
+			tree_stats = append(tree_stats, stats[pid])
+		}
+	}
+
+	return tree_stats, nil
+}
+
+// pid itself is also regarded as a descendant
+func isDescendantProcess(parents map[int]int, pid int, root_pid int) bool {
+	for i:=0; i<len(parents); i++ {
+		if pid == root_pid {
+			return true
+		}
+		ppid, ok := parents[pid]
+		if !ok || ppid == 0 {
+			return false
+		}
+		pid = ppid
+	}
+	return false
+}
+
+func parseProcStatParentAndThreads(stat []byte) (int, uint64, error) {
+	// the command name may contain spaces and parentheses, so fields are counted from the last ')'
+	end_of_comm := bytes.LastIndexByte(stat, ')')
+	if end_of_comm == -1 {
+		return 0, 0, errors.New("invalid stat (comm)")
+	}
+	// fields[0] is the 3rd field(state), so ppid(4th) and num_threads(20th) are fields[1] and fields[17]
+	fields := bytes.Fields(stat[end_of_comm + 1:])
+	if len(fields) < 18 {
+		return 0, 0, errors.New(fmt.Sprintf("invalid stat (num of fields %d)", len(fields)))
+	}
+
+	ppid, err := strconv.Atoi(string(fields[1]))
+	if err != nil { return 0, 0, err }
+	num_threads, err := strconv.ParseUint(string(fields[17]), 10, 64)
+	if err != nil { return 0, 0, err }
+
+	return ppid, num_threads, nil
+}
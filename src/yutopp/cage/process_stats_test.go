@@ -0,0 +1,48 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import (
+	"testing"
+	"os"
+)
+
+
+func TestUnitParseProcStatParentAndThreads(t *testing.T) {
+	stat := []byte("1234 (a) b (c)) R 42 1234 1234 0 -1 4194304 100 0 0 0 150 25 0 0 20 0 3 0 100 1000 10\n")
+
+	ppid, threads, err := parseProcStatParentAndThreads(stat)
+	if err != nil { t.Fatalf("%v", err) }
+	if ppid != 42 || threads != 3 {
+		t.Fatalf("ppid and threads should be 42 and 3 (but %d, %d)", ppid, threads)
+	}
+
+	if _, _, err := parseProcStatParentAndThreads([]byte("1234 (a) R 1")); err == nil {
+		t.Fatalf("truncated stat should be rejected")
+	}
+}
+
+func TestUnitCountProcessTree(t *testing.T) {
+	parents := map[int]int{ 1: 0, 10: 1, 11: 10, 12: 11, 20: 1 }
+	if !isDescendantProcess(parents, 12, 10) || !isDescendantProcess(parents, 10, 10) {
+		t.Fatalf("12 and 10 should be descendants of 10")
+	}
+	if isDescendantProcess(parents, 20, 10) || isDescendantProcess(parents, 1, 10) {
+		t.Fatalf("20 and 1 should NOT be descendants of 10")
+	}
+
+	// the current process
+	tree, err := readProcessTree(os.Getpid())
+	if err != nil { t.Fatalf("%v", err) }
+	if tree.processes < 1 || tree.threads < tree.processes {
+		t.Fatalf("invalid count (processes: %d, threads: %d)", tree.processes, tree.threads)
+	}
+}
@@ -0,0 +1,123 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+// #include <unistd.h>
+import "C"
+
+import(
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+
+// RLIMIT_CPU works only by seconds and per process, so CPU time is polled to enforce limits by milliseconds.
+// without cgroups, CPU time of the whole process tree is polled.
+// the interval is about 1% of the limit, so that long executions don't scan /proc too often
+const (
+	minCPUWatchdogInterval	= 10 * time.Millisecond
+	maxCPUWatchdogInterval	= 100 * time.Millisecond
+)
+
+var clockTicksPerSec = uint64(C.sysconf(C._SC_CLK_TCK))
+
+
+// kills the process if it exceeds limits, then sends the reason to killed_chan
+func watchProcess(
+	pass_kill_chan		<-chan bool,
+	killed_chan			chan<- ExecutedStatus,
+	rl					*ResourceLimit,
+	pid					int,
+	sample				func() (time.Duration, error),	// records statistics of the process, and returns CPU time
+) {
+	ticker := time.NewTicker(cpuWatchdogInterval(rl.CPUMs))
+	defer ticker.Stop()
+	wall_timer := time.After(time.Duration(rl.WallMs) * time.Millisecond)
+
+	// the reason is sent before killing, so that it is received after the process was waited
+	kill := func(status ExecutedStatus) {
+		killed_chan <- status
+		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+			log.Printf("Failed to kill a process(%d).\n", pid)
+		}
+	}
+
+	for {
+		select {
+		case <-pass_kill_chan:
+			/* DO NOTHING */
+			log.Println("PASS")
+			return
+
+		case <-ticker.C:
+			// the process may have exited already
+			cpu_time, err := sample()
+			if err != nil { continue }
+
+			if cpu_time > time.Duration(rl.CPUMs) * time.Millisecond {
+				log.Printf("Kill a process(%d) which used CPU %v.\n", pid, cpu_time)
+				kill(CPULimit)
+				return
+			}
+
+		case <-wall_timer:
+			log.Printf("Kill a sleeping process(%d).\n", pid)
+			kill(WallTimeLimit)
+			return
+		}
+	}
+}
+
+func cpuWatchdogInterval(cpu_ms uint64) time.Duration {
+	interval := time.Duration(cpu_ms / 100) * time.Millisecond
+	if interval < minCPUWatchdogInterval {
+		return minCPUWatchdogInterval
+	}
+	if interval > maxCPUWatchdogInterval {
+		return maxCPUWatchdogInterval
+	}
+	return interval
+}
+
+
+// user + system time of the process and its waited children
+func parseProcStatCPUTime(stat []byte, clock_ticks uint64) (time.Duration, error) {
+	// the command name may contain spaces and parentheses, so fields are counted from the last ')'
+	end_of_comm := bytes.LastIndexByte(stat, ')')
+	if end_of_comm == -1 {
+		return 0, errors.New("invalid stat (comm)")
+	}
+	// fields[0] is the 3rd field(state), so utime(14th), stime(15th), cutime(16th) and cstime(17th) are fields[11:15]
+	fields := bytes.Fields(stat[end_of_comm + 1:])
+	if len(fields) < 15 {
+		return 0, errors.New(fmt.Sprintf("invalid stat (num of fields %d)", len(fields)))
+	}
+
+	var ticks uint64 = 0
+	for _, field := range fields[11:15] {
+		// cutime and cstime are signed
+		t, err := strconv.ParseInt(string(field), 10, 64)
+		if err != nil { return 0, err }
+		if t > 0 {
+			ticks += uint64(t)
+		}
+	}
+
+	if clock_ticks == 0 {
+		return 0, errors.New("invalid clock ticks")
+	}
+	return time.Duration(ticks) * time.Second / time.Duration(clock_ticks), nil
+}
@@ -0,0 +1,103 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import (
+	"testing"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+
+func TestUnitParseProcStatCPUTime(t *testing.T) {
+	stat := []byte("1234 (a) b (c)) R 1 1234 1234 0 -1 4194304 100 0 0 0 150 25 0 0 20 0 1 0 100 1000 10\n")
+
+	cpu_time, err := parseProcStatCPUTime(stat, 100)
+	if err != nil { t.Fatalf("%v", err) }
+	if cpu_time != 1750 * time.Millisecond {
+		t.Fatalf("cpu time should be 1.75s (but %v)", cpu_time)
+	}
+
+	// times of waited children are included
+	stat = []byte("1234 (a) R 1 1234 1234 0 -1 4194304 100 0 0 0 150 25 50 -1 20 0 1 0 100 1000 10\n")
+	cpu_time, err = parseProcStatCPUTime(stat, 100)
+	if err != nil { t.Fatalf("%v", err) }
+	if cpu_time != 2250 * time.Millisecond {
+		t.Fatalf("cpu time should be 2.25s (but %v)", cpu_time)
+	}
+
+	if _, err := parseProcStatCPUTime([]byte("1234 (a) R 1"), 100); err == nil {
+		t.Fatalf("truncated stat should be rejected")
+	}
+}
+
+func TestUnitReadProcessTree(t *testing.T) {
+	// a descendant consumes CPU time, and the shell itself sleeps
+	cmd := exec.Command("sh", "-c", "(while :; do :; done) & sleep 10")
+	cmd.SysProcAttr = &syscall.SysProcAttr{ Setpgid: true }
+	if err := cmd.Start(); err != nil { t.Fatalf("%v", err) }
+	defer func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		cmd.Wait()
+	}()
+
+	time.Sleep(500 * time.Millisecond)
+
+	tree, err := readProcessTree(cmd.Process.Pid)
+	if err != nil { t.Fatalf("%v", err) }
+	if tree.cpuTime < 100 * time.Millisecond {
+		t.Fatalf("cpu time of descendants should be counted (but %v)", tree.cpuTime)
+	}
+	if tree.processes < 2 {
+		t.Fatalf("descendants should be counted (but %d)", tree.processes)
+	}
+}
+
+func TestUnitCPUWatchdogInterval(t *testing.T) {
+	cases := []struct {
+		cpuMs			uint64
+		expected		time.Duration
+	}{
+		{ 100, 10 * time.Millisecond },
+		{ 2000, 20 * time.Millisecond },
+		{ 60000, 100 * time.Millisecond },
+	}
+	for i, c := range cases {
+		if actual := cpuWatchdogInterval(c.cpuMs); actual != c.expected {
+			t.Fatalf("case %d: expected %v (but %v)", i, c.expected, actual)
+		}
+	}
+}
+
+func TestUnitWatchProcessWallTime(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil { t.Fatalf("%v", err) }
+
+	pass_kill_chan := make(chan bool)
+	killed_chan := make(chan ExecutedStatus, 1)
+	rl := &ResourceLimit{ CPUMs: 1000, WallMs: 100 }
+	sample := func() (time.Duration, error) { return 0, nil }
+	go watchProcess(pass_kill_chan, killed_chan, rl, cmd.Process.Pid, sample)
+
+	cmd.Wait()
+	defer close(pass_kill_chan)
+
+	// the reason must be received once the process was waited
+	select {
+	case status := <-killed_chan:
+		if status != WallTimeLimit {
+			t.Fatalf("status should be WallTimeLimit (but %v)", status)
+		}
+	default:
+		t.Fatalf("the reason should be sent before the process is killed")
+	}
+}
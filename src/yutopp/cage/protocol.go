@@ -41,8 +41,28 @@ const (
 	MessageKindSystemResult				= MessageKind(11)
 	MessageKindProcTable				= MessageKind(12)
 
+	// Sent from client
+	MessageKindGetHistoryRequest		= MessageKind(13)
+
+	// Sent from server
+	MessageKindHistory					= MessageKind(14)
+	MessageKindScoreSummary				= MessageKind(15)
+	MessageKindArtifacts				= MessageKind(16)
+
+	// Sent from client
+	MessageKindUploadBlobRequest		= MessageKind(17)
+
+	// Sent from server
+	MessageKindMissingBlobs				= MessageKind(18)
+
+	// Sent from client
+	MessageKindValidateTicketRequest	= MessageKind(19)
+
+	// Sent from server
+	MessageKindValidationReport			= MessageKind(20)
+
 	//
-	MessageKindIndexEnd					= MessageKind(12)
+	MessageKindIndexEnd					= MessageKind(20)
 	MessageKindInvalid					= MessageKind(0xff)
 )
 
@@ -61,6 +81,12 @@ func (k MessageKind) String() string {
 		return "MessageKindUpdateProcTableRequest"
 	case MessageKindGetProcTableRequest:
 		return "MessageKindGetProcTableRequest"
+	case MessageKindGetHistoryRequest:
+		return "MessageKindGetHistoryRequest"
+	case MessageKindUploadBlobRequest:
+		return "MessageKindUploadBlobRequest"
+	case MessageKindValidateTicketRequest:
+		return "MessageKindValidateTicketRequest"
 	default:
 		return fmt.Sprintf("%d", k)
 	}
@@ -236,3 +262,43 @@ func (ph *ProtocolHandler) writeProcTable(
 ) error {
 	return ph.write(writer, MessageKindProcTable, proc_config_table)
 }
+
+//
+func (ph *ProtocolHandler) writeHistory(
+	writer				io.Writer,
+	records				[]*HistoryRecord,
+) error {
+	return ph.write(writer, MessageKindHistory, records)
+}
+
+//
+func (ph *ProtocolHandler) writeScoreSummary(
+	writer				io.Writer,
+	r					*StreamScoreSummary,
+) error {
+	return ph.write(writer, MessageKindScoreSummary, r.ToTuple())
+}
+
+//
+func (ph *ProtocolHandler) writeArtifacts(
+	writer				io.Writer,
+	r					*StreamArtifacts,
+) error {
+	return ph.write(writer, MessageKindArtifacts, r.ToTuple())
+}
+
+//
+func (ph *ProtocolHandler) writeMissingBlobs(
+	writer				io.Writer,
+	hashes				[]string,
+) error {
+	return ph.write(writer, MessageKindMissingBlobs, hashes)
+}
+
+//
+func (ph *ProtocolHandler) writeValidationReport(
+	writer				io.Writer,
+	r					*ValidationReport,
+) error {
+	return ph.write(writer, MessageKindValidationReport, r.ToTuple())
+}
@@ -61,6 +61,8 @@ int main() {
 }
 `),
 			false,
+			false,
+			"",
 		},
 	}
 
@@ -92,6 +94,8 @@ int main() {
 					"hoge.in",
 					[]byte("100"),
 					false,
+					false,
+					"",
 				},
 				setting: &ExecutionSetting{
 					CpuTimeLimit: 10,
@@ -0,0 +1,317 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import(
+	"log"
+	"sync"
+	"time"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/ugorji/go/codec"
+)
+
+
+// ========================================
+// results which are replayed on cache hits
+type cachedFrame struct {
+	Output				*StreamOutputResult
+	Executed			*StreamExecutedResult
+	ScoreSummary		*StreamScoreSummary
+	Artifacts			*StreamArtifacts
+}
+
+func encodeCachedFrames(frames []*cachedFrame) ([]byte, error) {
+	var msgpack_bytes []byte
+	enc := codec.NewEncoderBytes(&msgpack_bytes, &msgPackHandler)
+	if err := enc.Encode(frames); err != nil {
+		return nil, err
+	}
+	return msgpack_bytes, nil
+}
+
+func decodeCachedFrames(base []byte) ([]*cachedFrame, error) {
+	var frames []*cachedFrame
+	dec := codec.NewDecoderBytes(base, &msgPackHandler)
+	if err := dec.Decode(&frames); err != nil {
+		return nil, err
+	}
+	return frames, nil
+}
+
+// records results to store them into the cache
+func makeFrameRecorder(
+	frames				*[]*cachedFrame,
+	callback			invokeResultRecieverCallback,
+) invokeResultRecieverCallback {
+	return func(v interface{}) {
+		switch r := v.(type) {
+		case *StreamOutputResult:
+			*frames = append(*frames, &cachedFrame{ Output: r })
+		case *StreamExecutedResult:
+			*frames = append(*frames, &cachedFrame{ Executed: r })
+		case *StreamScoreSummary:
+			*frames = append(*frames, &cachedFrame{ ScoreSummary: r })
+		case *StreamArtifacts:
+			*frames = append(*frames, &cachedFrame{ Artifacts: r })
+		}
+
+		if callback != nil {
+			callback(v)
+		}
+	}
+}
+
+// executed results are marked as cached
+func replayCachedFrames(
+	frames				[]*cachedFrame,
+	callback			invokeResultRecieverCallback,
+) {
+	if callback == nil { return }
+
+	for _, f := range frames {
+		switch {
+		case f.Output != nil:
+			callback(f.Output)
+		case f.Executed != nil:
+			executed := *f.Executed
+			executed.IsCached = true
+			callback(&executed)
+		case f.ScoreSummary != nil:
+			callback(f.ScoreSummary)
+		case f.Artifacts != nil:
+			callback(f.Artifacts)
+		}
+	}
+}
+
+
+// ========================================
+// zero values mean "unlimited"
+type ResultCacheLimits struct {
+	MaxAge				time.Duration
+	MaxBytes			uint64
+	MaxEntries			int
+}
+
+//
+type resultCacheEntry struct {
+	key					string
+	data				[]byte		// encoded frames
+	storedAt			time.Time
+}
+
+// results of whole tickets are kept in memory. least recently used entries are evicted first
+type ResultCache struct {
+	limits				ResultCacheLimits
+
+	mutex				sync.Mutex
+	entries				map[string]*list.Element
+	order				*list.List		// the front is the most recently used
+	totalBytes			uint64
+}
+
+func NewResultCache(limits ResultCacheLimits) *ResultCache {
+	return &ResultCache{
+		limits: limits,
+		entries: map[string]*list.Element{},
+		order: list.New(),
+	}
+}
+
+func (c *ResultCache) load(key string) ([]*cachedFrame, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.evictLocked(time.Now())
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+
+	frames, err := decodeCachedFrames(elem.Value.(*resultCacheEntry).data)
+	if err != nil {
+		log.Printf("ResultCache::broken entry %s (%v)\n", key, err)
+		c.removeLocked(elem)
+		return nil, false
+	}
+
+	return frames, true
+}
+
+func (c *ResultCache) store(key string, frames []*cachedFrame) error {
+	data, err := encodeCachedFrames(frames)
+	if err != nil { return err }
+
+	if c.limits.MaxBytes != 0 && uint64(len(data)) > c.limits.MaxBytes {
+		log.Printf("ResultCache::%s is too large to be cached (%d bytes)\n", key, len(data))
+		return nil
+	}
+
+	//
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.removeLocked(elem)
+	}
+
+	c.entries[key] = c.order.PushFront(&resultCacheEntry{
+		key: key,
+		data: data,
+		storedAt: time.Now(),
+	})
+	c.totalBytes += uint64(len(data))
+
+	c.evictLocked(time.Now())
+
+	return nil
+}
+
+func (c *ResultCache) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries = map[string]*list.Element{}
+	c.order.Init()
+	c.totalBytes = 0
+}
+
+func (c *ResultCache) evictLocked(now time.Time) {
+	for elem := c.order.Back(); elem != nil; elem = c.order.Back() {
+		entry := elem.Value.(*resultCacheEntry)
+
+		is_expired := c.limits.MaxAge != 0 && now.Sub(entry.storedAt) > c.limits.MaxAge
+		is_overflowed := (c.limits.MaxBytes != 0 && c.totalBytes > c.limits.MaxBytes) ||
+			(c.limits.MaxEntries != 0 && c.order.Len() > c.limits.MaxEntries)
+		if !is_expired && !is_overflowed {
+			// expired entries may remain until they become the least recently used
+			break
+		}
+
+		c.removeLocked(elem)
+	}
+}
+
+func (c *ResultCache) removeLocked(elem *list.Element) {
+	entry := elem.Value.(*resultCacheEntry)
+
+	c.order.Remove(elem)
+	delete(c.entries, entry.key)
+	c.totalBytes -= uint64(len(entry.data))
+}
+
+
+// ========================================
+// BaseName is not a part of the key, it doesn't affect results
+type resultCacheKeySeed struct {
+	ProcId				uint64
+	ProcVersion			string
+	Profile				*ProcProfile
+	Sources				[]string
+	BuildInst			*BuildInstruction
+	Inputs				[]resultCacheInputSeed
+	Subtasks			[]*Subtask
+	Policy				*ExecutionPolicy
+	Checker				*resultCacheJudgeSeed
+	Interactor			*resultCacheJudgeSeed
+	Artifacts			[]string
+}
+
+type resultCacheInputSeed struct {
+	Stdin				string
+	Setting				*ExecutionSetting
+	Expected			string
+	Comparator			*OutputComparator
+	Args				[][]string
+	Files				[]string
+}
+
+type resultCacheJudgeSeed struct {
+	ProcId				uint64
+	ProcVersion			string
+	Sources				[]string
+	BuildInst			*BuildInstruction
+	RunSetting			*ExecutionSetting
+}
+
+func digestSources(sources []*SourceData) []string {
+	digests := make([]string, len(sources))
+	for i, source := range sources {
+		digests[i] = digestSource(source)
+	}
+	return digests
+}
+
+func makeResultCacheJudgeSeed(program *JudgeProgram) *resultCacheJudgeSeed {
+	if program == nil { return nil }
+
+	return &resultCacheJudgeSeed{
+		ProcId: program.ProcId,
+		ProcVersion: program.ProcVersion,
+		Sources: digestSources(program.Sources),
+		BuildInst: program.BuildInst,
+		RunSetting: program.RunSetting,
+	}
+}
+
+// the key is changed if anything in the ticket is changed
+func makeResultCacheKey(
+	ticket				*Ticket,
+	proc_profile		*ProcProfile,
+) (string, error) {
+	seed := &resultCacheKeySeed{
+		ProcId: ticket.ProcId,
+		ProcVersion: ticket.ProcVersion,
+		Profile: proc_profile,
+		Sources: digestSources(ticket.Sources),
+		BuildInst: ticket.BuildInst,
+		Checker: makeResultCacheJudgeSeed(ticket.Checker),
+		Interactor: makeResultCacheJudgeSeed(ticket.Interactor),
+		Artifacts: ticket.Artifacts,
+	}
+
+	if ticket.RunInst != nil {
+		seed.Subtasks = ticket.RunInst.Subtasks
+		seed.Policy = ticket.RunInst.Policy
+		for _, input := range ticket.RunInst.Inputs {
+			seed.Inputs = append(seed.Inputs, resultCacheInputSeed{
+				Stdin: digestSource(input.stdin),
+				Setting: input.setting,
+				Expected: digestSource(input.expected),
+				Comparator: input.comparator,
+				Args: input.args,
+				Files: digestSources(input.files),
+			})
+		}
+	}
+
+	seed_bytes, err := json.Marshal(seed)
+	if err != nil { return "", err }
+
+	h := sha256.Sum256(append([]byte("result-cache-v1\n"), seed_bytes...))
+	return hex.EncodeToString(h[:]), nil
+}
+
+// results are NOT cached if the system was failed
+func isCacheableResult(frames []*cachedFrame) bool {
+	for _, f := range frames {
+		if f.Executed != nil && f.Executed.Result != nil && f.Executed.Result.Status == UnexpectedError {
+			return false
+		}
+	}
+	return true
+}
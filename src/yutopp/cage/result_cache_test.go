@@ -0,0 +1,101 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import (
+	"testing"
+)
+
+
+func makeResultCacheTestTicket(base_name string, stdin string) *Ticket {
+	return &Ticket{
+		BaseName: base_name,
+		ProcId: 0,
+		ProcVersion: "test",
+		Sources: []*SourceData{ &SourceData{ Name: "prog.cpp", Data: []byte("int main() {}") } },
+		RunInst: &RunInstruction{
+			Inputs: []Input{
+				Input{
+					stdin: &SourceData{ Name: "in", Data: []byte(stdin) },
+					setting: &ExecutionSetting{ CpuTimeLimit: 10, MemoryBytesLimit: 1024 },
+				},
+			},
+		},
+		UseResultCache: true,
+	}
+}
+
+func TestUnitResultCacheKey(t *testing.T) {
+	profile := &ProcProfile{ Version: "test" }
+
+	a, err := makeResultCacheKey(makeResultCacheTestTicket("aaa", "1 2"), profile)
+	if err != nil { t.Fatalf("%v", err) }
+	b, err := makeResultCacheKey(makeResultCacheTestTicket("bbb", "1 2"), profile)
+	if err != nil { t.Fatalf("%v", err) }
+	if a != b {
+		t.Fatalf("base name should NOT affect the key")
+	}
+
+	c, err := makeResultCacheKey(makeResultCacheTestTicket("aaa", "1 3"), profile)
+	if err != nil { t.Fatalf("%v", err) }
+	if a == c {
+		t.Fatalf("inputs should affect the key")
+	}
+
+	d, err := makeResultCacheKey(makeResultCacheTestTicket("aaa", "1 2"), &ProcProfile{ Version: "test", IsBuildRequired: true })
+	if err != nil { t.Fatalf("%v", err) }
+	if a == d {
+		t.Fatalf("profile should affect the key")
+	}
+}
+
+func TestUnitResultCacheReplay(t *testing.T) {
+	cache := NewResultCache(ResultCacheLimits{ MaxEntries: 2 })
+
+	frames := []*cachedFrame{
+		&cachedFrame{ Output: &StreamOutputResult{ Mode: RunMode, Index: 0, Output: &StreamOutput{ Fd: StdoutFd, Buffer: []byte("3") } } },
+		&cachedFrame{ Executed: &StreamExecutedResult{ Mode: RunMode, Index: 0, Result: &ExecutedResult{ Status: Passed } } },
+	}
+	for _, key := range []string{ "a", "b" } {
+		if err := cache.store(key, frames); err != nil { t.Fatalf("%v", err) }
+	}
+
+	// "a" becomes the most recently used, so "b" is evicted
+	if _, ok := cache.load("a"); !ok {
+		t.Fatalf("a should be cached")
+	}
+	if err := cache.store("c", frames); err != nil { t.Fatalf("%v", err) }
+	if _, ok := cache.load("b"); ok {
+		t.Fatalf("b should be evicted")
+	}
+
+	cached, ok := cache.load("a")
+	if !ok {
+		t.Fatalf("a should be cached")
+	}
+
+	var replayed []interface{}
+	replayCachedFrames(cached, func(v interface{}) { replayed = append(replayed, v) })
+	if len(replayed) != 2 {
+		t.Fatalf("2 frames should be replayed (but %d)", len(replayed))
+	}
+	if o, ok := replayed[0].(*StreamOutputResult); !ok || string(o.Output.Buffer) != "3" {
+		t.Fatalf("output should be replayed (but %v)", replayed[0])
+	}
+	if r, ok := replayed[1].(*StreamExecutedResult); !ok || !r.IsCached || r.Result.Status != Passed {
+		t.Fatalf("executed result should be replayed as cached (but %v)", replayed[1])
+	}
+
+	cache.Clear()
+	if _, ok := cache.load("a"); ok {
+		t.Fatalf("cache should be cleared")
+	}
+}
@@ -0,0 +1,90 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+package torigoya
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"path/filepath"
+)
+
+
+// limitations of names of files which are placed into the jailed HOME
+const (
+	maxSourceNameLength			= 255
+	maxSourceComponentLength	= 64
+	maxSourceDepth				= 8
+)
+
+// relative paths separated by "/". e.g. "main.cpp", "src/main.rs"
+func validateSourceName(name string) error {
+	if len(name) == 0 {
+		return errors.New("source name must NOT be empty")
+	}
+	if len(name) > maxSourceNameLength {
+		return errors.New(fmt.Sprintf("source name is too long (%d > %d)", len(name), maxSourceNameLength))
+	}
+	if strings.HasPrefix(name, "/") {
+		return errors.New(fmt.Sprintf("source name must be a relative path (%s)", name))
+	}
+
+	components := strings.Split(name, "/")
+	if len(components) > maxSourceDepth {
+		return errors.New(fmt.Sprintf("source name is too deep (%s)", name))
+	}
+
+	for _, c := range components {
+		if c == "" || c == "." || c == ".." {
+			return errors.New(fmt.Sprintf("source name contains an invalid component (%s)", name))
+		}
+		if len(c) > maxSourceComponentLength {
+			return errors.New(fmt.Sprintf("component of source name is too long (%s)", name))
+		}
+		if strings.HasPrefix(c, "-") {
+			return errors.New(fmt.Sprintf("component of source name must NOT start with '-' (%s)", name))
+		}
+
+		for _, r := range c {
+			if !isValidSourceNameRune(r) {
+				return errors.New(fmt.Sprintf("source name contains an invalid character %q (%s)", r, name))
+			}
+		}
+	}
+
+	return nil
+}
+
+func isValidSourceNameRune(r rune) bool {
+	switch {
+	case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		return true
+	case r == '.' || r == '_' || r == '-' || r == '+':
+		return true
+	default:
+		return false
+	}
+}
+
+// patterns are matched with relative paths from HOME. e.g. "*.csv", "out/*.png"
+func validateArtifactPattern(pattern string) error {
+	if pattern == "" || strings.HasPrefix(pattern, "/") {
+		return errors.New(fmt.Sprintf("artifact pattern must be a relative path (%s)", pattern))
+	}
+	for _, c := range strings.Split(pattern, "/") {
+		if c == ".." {
+			return errors.New(fmt.Sprintf("artifact pattern must NOT contain '..' (%s)", pattern))
+		}
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return errors.New(fmt.Sprintf("invalid artifact pattern (%s)", pattern))
+	}
+
+	return nil
+}
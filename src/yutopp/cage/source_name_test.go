@@ -0,0 +1,50 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+package torigoya
+
+import (
+	"strings"
+	"testing"
+)
+
+
+func TestUnitValidateSourceName(t *testing.T) {
+	valid := []string{
+		"prog.cpp",
+		"src/main.rs",
+		"pkg/util/util_test.go",
+		"a-b_c+d.txt",
+	}
+	for _, name := range valid {
+		if err := validateSourceName(name); err != nil {
+			t.Fatalf("%s should be valid (%v)", name, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"/etc/passwd",
+		"../x",
+		"src/../../x",
+		"./main.cpp",
+		"src//main.rs",
+		"src/",
+		"-rf",
+		"main cpp",
+		"main\x00.cpp",
+		"ma\\in.cpp",
+		strings.Repeat("a", 65),
+		strings.Repeat("a/", 8) + "a",
+	}
+	for _, name := range invalid {
+		if err := validateSourceName(name); err == nil {
+			t.Fatalf("%q should be invalid", name)
+		}
+	}
+}
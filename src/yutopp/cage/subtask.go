@@ -0,0 +1,244 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+package torigoya
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+
+// ========================================
+type ScoringRule	int
+const (
+	AllOrNothingScoring		= ScoringRule(0)	// full points only if all inputs were accepted
+	MinScoring				= ScoringRule(1)	// points * minimum score of inputs (checkers may give partial scores)
+	SumScoring				= ScoringRule(2)	// points * average score of inputs
+)
+
+//
+type Subtask struct {
+	Name			string
+	Points			float64
+	Rule			ScoringRule
+	InputIndices	[]int
+}
+
+
+// ========================================
+type SubtaskScore struct {
+	Name			string
+	Points			float64
+	Score			float64
+	Rule			ScoringRule
+	AcceptedCount	int
+	TotalCount		int
+	SkippedIndices	[]int
+}
+
+func (s *SubtaskScore) ToTuple() []interface{} {
+	return []interface{}{ s.Name, s.Points, s.Score, s.Rule, s.AcceptedCount, s.TotalCount, s.SkippedIndices }
+}
+
+//
+type ScoreSummary struct {
+	Score			float64
+	MaxScore		float64
+	Subtasks		[]*SubtaskScore
+}
+
+func (s *ScoreSummary) ToTuple() []interface{} {
+	subtasks := make([]interface{}, len(s.Subtasks))
+	for i, st := range s.Subtasks {
+		subtasks[i] = st.ToTuple()
+	}
+
+	return []interface{}{ s.Score, s.MaxScore, subtasks }
+}
+
+// sent to the callback after all results of inputs
+type StreamScoreSummary struct {
+	Summary			*ScoreSummary
+}
+
+func (r *StreamScoreSummary) ToTuple() []interface{} {
+	return r.Summary.ToTuple()
+}
+
+
+// ========================================
+type inputScoreState int
+const (
+	inputNotExecuted	= inputScoreState(iota)
+	inputAccepted
+	inputRejected
+	inputSkipped
+)
+
+// collects scores of inputs while running
+type subtaskScorer struct {
+	subtasks		[]*Subtask
+	states			[]inputScoreState
+	scores			[]float64		// in [0, 1], 0 if the input was not accepted at all
+}
+
+func newSubtaskScorer(subtasks []*Subtask, inputs_num int) *subtaskScorer {
+	return &subtaskScorer{
+		subtasks: subtasks,
+		states: make([]inputScoreState, inputs_num),
+		scores: make([]float64, inputs_num),
+	}
+}
+
+// an input is skipped only if all subtasks which contain it have already failed
+func (s *subtaskScorer) shouldSkip(index int) bool {
+	belongs := false
+	for _, subtask := range s.subtasks {
+		if !subtask.contains(index) {
+			continue
+		}
+		belongs = true
+
+		if !s.isDecided(subtask) {
+			return false
+		}
+	}
+
+	return belongs
+}
+
+func (s *subtaskScorer) record(index int, score float64) {
+	if score >= 1 {
+		s.states[index] = inputAccepted
+	} else {
+		s.states[index] = inputRejected
+	}
+	s.scores[index] = score
+}
+
+func (s *subtaskScorer) skip(index int) {
+	s.states[index] = inputSkipped
+}
+
+// the score of the subtask is 0 whatever remaining inputs are.
+// a partial score does not decide the minimum, because remaining inputs may have lower scores
+func (s *subtaskScorer) isDecided(subtask *Subtask) bool {
+	if subtask.Rule == SumScoring {
+		return false
+	}
+
+	for _, index := range subtask.InputIndices {
+		switch s.states[index] {
+		case inputSkipped:
+			return true
+		case inputRejected:
+			if subtask.Rule == AllOrNothingScoring || s.scores[index] == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *subtaskScorer) summary() *ScoreSummary {
+	summary := &ScoreSummary{
+		Subtasks: make([]*SubtaskScore, len(s.subtasks)),
+	}
+
+	for i, subtask := range s.subtasks {
+		score := &SubtaskScore{
+			Name: subtask.Name,
+			Points: subtask.Points,
+			Rule: subtask.Rule,
+			TotalCount: len(subtask.InputIndices),
+			SkippedIndices: []int{},
+		}
+
+		// inputs which were not executed are scored 0
+		var sum_of_scores, min_score float64 = 0, 1
+		for _, index := range subtask.InputIndices {
+			switch s.states[index] {
+			case inputAccepted:
+				score.AcceptedCount++
+			case inputSkipped:
+				score.SkippedIndices = append(score.SkippedIndices, index)
+			}
+			sum_of_scores += s.scores[index]
+			min_score = math.Min(min_score, s.scores[index])
+		}
+
+		if score.TotalCount > 0 {
+			switch subtask.Rule {
+			case SumScoring:
+				score.Score = subtask.Points * sum_of_scores / float64(score.TotalCount)
+			case MinScoring:
+				score.Score = subtask.Points * min_score
+			default:
+				if score.AcceptedCount == score.TotalCount {
+					score.Score = subtask.Points
+				}
+			}
+		}
+
+		summary.Score += score.Score
+		summary.MaxScore += subtask.Points
+		summary.Subtasks[i] = score
+	}
+
+	return summary
+}
+
+func (st *Subtask) contains(index int) bool {
+	for _, i := range st.InputIndices {
+		if i == index { return true }
+	}
+	return false
+}
+
+
+// ========================================
+func MakeSubtaskFromTuple(tupled interface{}, inputs_num int) (*Subtask, error) {
+	if tupled == nil { return nil, errors.New("Subtask::invalid data(nil)") }
+	interface_array, ok := tupled.([]interface{})
+	if !ok { return nil, errors.New("Subtask::invalid data(total)") }
+	if len(interface_array) != 4 { return nil, errors.New("Subtask::invalid data(num of lement)") }
+
+	//
+	name_bytes, ok := interface_array[0].([]byte)
+	if !ok { return nil, errors.New("Subtask::invalid data(0)") }
+
+	//
+	points, ok := readFloat(interface_array[1])
+	if !ok || points < 0 { return nil, errors.New("Subtask::invalid data(1)") }
+
+	//
+	rule, ok := readUInt(interface_array[2])
+	if !ok { return nil, errors.New("Subtask::invalid data(2)") }
+	if ScoringRule(rule) > SumScoring { return nil, errors.New("Subtask::invalid data(2) unknown rule") }
+
+	//
+	indices_array, ok := interface_array[3].([]interface{})
+	if !ok { return nil, errors.New("Subtask::invalid data(3)") }
+	indices := make([]int, len(indices_array))
+	for i, index_interface := range indices_array {
+		index, ok := readUInt(index_interface)
+		if !ok || index >= uint64(inputs_num) {
+			return nil, errors.New(fmt.Sprintf("Subtask::invalid data(3) index %v is out of range", index_interface))
+		}
+		indices[i] = int(index)
+	}
+
+	return &Subtask{
+		Name: string(name_bytes),
+		Points: points,
+		Rule: ScoringRule(rule),
+		InputIndices: indices,
+	}, nil
+}
@@ -0,0 +1,111 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+package torigoya
+
+import (
+	"testing"
+)
+
+
+func TestUnitSubtaskScorer(t *testing.T) {
+	subtasks := []*Subtask{
+		&Subtask{ Name: "small", Points: 30, Rule: AllOrNothingScoring, InputIndices: []int{ 0, 1, 2 } },
+		&Subtask{ Name: "large", Points: 70, Rule: MinScoring, InputIndices: []int{ 2, 3, 4 } },
+		&Subtask{ Name: "partial", Points: 10, Rule: SumScoring, InputIndices: []int{ 4, 5 } },
+	}
+	scores := []float64{ 1, 0, 1, 1, 1, 0 }
+
+	scorer := newSubtaskScorer(subtasks, len(scores))
+	var executed []int
+	for i, score := range scores {
+		if scorer.shouldSkip(i) {
+			scorer.skip(i)
+			continue
+		}
+		executed = append(executed, i)
+		scorer.record(i, score)
+	}
+
+	// input 2 belongs to "large" which has not failed yet
+	if len(executed) != 6 {
+		t.Fatalf("all inputs should be executed (but %v)", executed)
+	}
+
+	summary := scorer.summary()
+	if summary.MaxScore != 110 {
+		t.Fatalf("max score should be 110 (but %v)", summary.MaxScore)
+	}
+	if summary.Score != 75 {
+		t.Fatalf("score should be 75 (but %v)", summary.Score)
+	}
+	if summary.Subtasks[0].Score != 0 || summary.Subtasks[1].Score != 70 || summary.Subtasks[2].Score != 5 {
+		t.Fatalf("invalid subtask scores %v %v %v", summary.Subtasks[0], summary.Subtasks[1], summary.Subtasks[2])
+	}
+}
+
+func TestUnitSubtaskScorerSkip(t *testing.T) {
+	subtasks := []*Subtask{
+		&Subtask{ Name: "a", Points: 50, Rule: AllOrNothingScoring, InputIndices: []int{ 0, 1, 2 } },
+		&Subtask{ Name: "b", Points: 50, Rule: SumScoring, InputIndices: []int{ 3, 4 } },
+	}
+	scores := []float64{ 0, 1, 1, 0, 1 }
+
+	scorer := newSubtaskScorer(subtasks, len(scores))
+	for i, score := range scores {
+		if scorer.shouldSkip(i) {
+			scorer.skip(i)
+			continue
+		}
+		scorer.record(i, score)
+	}
+
+	summary := scorer.summary()
+	if len(summary.Subtasks[0].SkippedIndices) != 2 {
+		t.Fatalf("inputs 1 and 2 should be skipped (but %v)", summary.Subtasks[0].SkippedIndices)
+	}
+	if len(summary.Subtasks[1].SkippedIndices) != 0 {
+		t.Fatalf("sum scoring should not skip inputs (but %v)", summary.Subtasks[1].SkippedIndices)
+	}
+	if summary.Score != 25 {
+		t.Fatalf("score should be 25 (but %v)", summary.Score)
+	}
+}
+
+func TestUnitSubtaskScorerPartialScores(t *testing.T) {
+	subtasks := []*Subtask{
+		&Subtask{ Name: "all", Points: 20, Rule: AllOrNothingScoring, InputIndices: []int{ 0, 1, 2 } },
+		&Subtask{ Name: "min", Points: 40, Rule: MinScoring, InputIndices: []int{ 0, 1, 2 } },
+		&Subtask{ Name: "sum", Points: 60, Rule: SumScoring, InputIndices: []int{ 0, 1, 2 } },
+	}
+	scores := []float64{ 1, 0.5, 0.25 }
+
+	scorer := newSubtaskScorer(subtasks, len(scores))
+	for i, score := range scores {
+		// partial scores don't decide the minimum, so remaining inputs are executed
+		if scorer.shouldSkip(i) {
+			t.Fatalf("input %d should not be skipped", i)
+		}
+		scorer.record(i, score)
+	}
+
+	summary := scorer.summary()
+	if summary.Subtasks[0].Score != 0 || summary.Subtasks[1].Score != 10 || summary.Subtasks[2].Score != 35 {
+		t.Fatalf("invalid subtask scores %v %v %v", summary.Subtasks[0], summary.Subtasks[1], summary.Subtasks[2])
+	}
+	if summary.Subtasks[1].AcceptedCount != 1 {
+		t.Fatalf("only input 0 should be accepted (but %d)", summary.Subtasks[1].AcceptedCount)
+	}
+
+	// the minimum is decided by a score 0
+	scorer = newSubtaskScorer(subtasks[1:2], 3)
+	scorer.record(0, 0)
+	if !scorer.shouldSkip(1) {
+		t.Fatalf("input 1 should be skipped after the score 0")
+	}
+}
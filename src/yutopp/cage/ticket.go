@@ -10,6 +10,7 @@ package torigoya
 
 import (
 	"errors"
+	"fmt"
 )
 
 
@@ -19,18 +20,34 @@ type SourceData struct {
 	Name			string
 	Data			[]byte
 	IsCompressed	bool
+	IsArchive		bool		// Data is tar(.gz) or zip which contains multiple files
+	BlobHash		string		// Data is taken from the blob store if given
 }
 
 func convertSourcesToContents(
 	sources []*SourceData,
 ) (source_contents []*TextContent, err error) {
-	source_contents = make([]*TextContent, len(sources))
+	source_contents = make([]*TextContent, 0, len(sources))
+
+	// limitations of archives are applied to all of them
+	extractor := &archiveExtractor{}
 
 	//
-	for i, s := range sources {
+	for _, s := range sources {
+		// archives are expanded to files
+		if s.IsArchive {
+			contents, err := extractArchive(extractor, s.Data)
+			if err != nil { return nil, err }
+
+			source_contents = append(source_contents, contents...)
+			continue
+		}
+
 		// collect file names
-		source_contents[i], err = convertSourceToContent(s)
+		content, err := convertSourceToContent(s)
 		if err != nil { return nil,err }
+
+		source_contents = append(source_contents, content)
 	}
 
 	return source_contents, nil
@@ -39,6 +56,10 @@ func convertSourcesToContents(
 func convertSourceToContent(
 	source *SourceData,
 ) (*TextContent, error) {
+	if source.IsArchive {
+		return nil, errors.New(fmt.Sprintf("archive can not be used as a single file (%s)", source.Name))
+	}
+
 	data, err := func() ([]byte, error) {
 		// TODO: check that data is compressed
 		return source.Data, nil
@@ -60,6 +81,38 @@ type ExecutionSetting struct {
 	StructuredCommand	[][]string
 	CpuTimeLimit		uint64
 	MemoryBytesLimit	uint64
+	ReportFileChanges	bool				// optional, changes of files in HOME are reported if true
+	WallTimeLimit		uint64				// optional, the default (CpuTimeLimit + 5) is used if 0
+	CpuTimeLimitMs		uint64				// optional, overrides CpuTimeLimit if not 0
+	WallTimeLimitMs		uint64				// optional, overrides WallTimeLimit if not 0
+	StdoutLimitBytes	uint64				// optional, the default of the phase is used if 0
+	StderrLimitBytes	uint64				// optional, the default of the phase is used if 0
+	OverrideLimits		bool				// optional, limits of the link phase are taken from this setting if true
+	StackLimitBytes		uint64				// optional, the default of the phase is used if 0, StackUnlimited is also accepted
+	IsStackSameAsMemory	bool				// optional, the stack can grow up to MemoryBytesLimit if true
+}
+
+// StackLimitBytes which requests the unlimited stack (RLIM_INFINITY).
+// it is accepted only if neither the server nor the profile bounds the stack
+const StackUnlimited = ^uint64(0)
+
+// limits in milliseconds
+func (s *ExecutionSetting) cpuTimeLimitMs() uint64 {
+	if s.CpuTimeLimitMs != 0 {
+		return s.CpuTimeLimitMs
+	}
+	return s.CpuTimeLimit * 1000
+}
+
+func (s *ExecutionSetting) wallTimeLimitMs() uint64 {
+	switch {
+	case s.WallTimeLimitMs != 0:
+		return s.WallTimeLimitMs
+	case s.WallTimeLimit != 0:
+		return s.WallTimeLimit * 1000
+	default:
+		return s.cpuTimeLimitMs() + 5000
+	}
 }
 
 
@@ -74,12 +127,59 @@ type BuildInstruction struct {
 type Input struct{
 	stdin				*SourceData
 	setting				*ExecutionSetting
+	expected			*SourceData			// optional, output is judged by the server if given
+	comparator			*OutputComparator	// exact comparison is used if nil
+	args				[][]string			// optional, appended to structured commands of the setting
+	files				[]*SourceData		// optional, placed into HOME as read only files
 }
 
 
 // ========================================
 type RunInstruction struct {
 	Inputs				[]Input
+	Subtasks			[]*Subtask			// optional, scores are summarized if given
+	Policy				*ExecutionPolicy	// optional, all inputs are executed if nil
+}
+
+
+// ========================================
+type ExecutionPolicyKind	int
+const (
+	RunAllPolicy				= ExecutionPolicyKind(0)
+	StopAtFirstFailurePolicy	= ExecutionPolicyKind(1)
+	StopAfterFailuresPolicy		= ExecutionPolicyKind(2)
+)
+
+// a failure is an input which was not passed or was rejected by the judge.
+// if inputs are executed in parallel, inputs which are already running when the policy decides to stop are
+// NOT cancelled and report their real results. only inputs which have not been started are reported as Skipped
+type ExecutionPolicy struct {
+	Kind				ExecutionPolicyKind
+	MaxFailures			uint64				// used by StopAfterFailuresPolicy
+}
+
+func (p *ExecutionPolicy) shouldStop(failures uint64) bool {
+	if p == nil { return false }
+
+	switch p.Kind {
+	case StopAtFirstFailurePolicy:
+		return failures >= 1
+	case StopAfterFailuresPolicy:
+		return failures >= p.MaxFailures
+	default:
+		return false
+	}
+}
+
+
+// ========================================
+// a program that is prepared by the server to judge outputs of the ticket
+type JudgeProgram struct {
+	ProcId			uint64
+	ProcVersion		string
+	Sources			[]*SourceData
+	BuildInst		*BuildInstruction
+	RunSetting		*ExecutionSetting
 }
 
 
@@ -91,6 +191,10 @@ type Ticket struct {
 	Sources			[]*SourceData
 	BuildInst		*BuildInstruction
 	RunInst			*RunInstruction
+	Checker			*JudgeProgram		// optional
+	Interactor		*JudgeProgram		// optional
+	Artifacts		[]string			// optional, glob patterns of files which are collected after executions
+	UseResultCache	bool				// optional, results are replayed if the same ticket was executed
 }
 
 
@@ -103,21 +207,46 @@ func MakeSourceDataFromTuple(tupled interface{}) (*SourceData, error) {
 	if tupled == nil { return nil, nil }
 	interface_array, ok := tupled.([]interface{})
 	if !ok { return nil, errors.New("SourceData::invalid data(total)") }
-	if len(interface_array) != 3 { return nil, errors.New("SourceData::invalid data(num of lement)") }
+	if len(interface_array) < 3 || len(interface_array) > 5 { return nil, errors.New("SourceData::invalid data(num of lement)") }
 
 	name_bytes, ok := interface_array[0].([]byte)
 	if !ok { return nil, errors.New("SourceData::invalid data(0)") }
 
-	data_byte, ok := interface_array[1].([]byte)
-	if !ok { return nil, errors.New("SourceData::invalid data(1)") }
+	// optional, referenced blob
+	blob_hash := ""
+	if v := readTupleElement(interface_array, 4); v != nil {
+		blob_hash_bytes, ok := v.([]byte)
+		if !ok { return nil, errors.New("SourceData::invalid data(4)") }
+		if err := validateBlobHash(string(blob_hash_bytes)); err != nil { return nil, err }
+		blob_hash = string(blob_hash_bytes)
+	}
+
+	// data can be nil if the blob is referenced
+	var data_byte []byte = nil
+	if interface_array[1] != nil || blob_hash == "" {
+		data_byte, ok = interface_array[1].([]byte)
+		if !ok { return nil, errors.New("SourceData::invalid data(1)") }
+	}
+	if blob_hash != "" && len(data_byte) != 0 {
+		return nil, errors.New("SourceData::invalid data(1) data must be empty if the blob is referenced")
+	}
 
 	is_compressed, ok := interface_array[2].(bool)
 	if !ok { return nil, errors.New("SourceData::invalid data(2)") }
 
+	// optional
+	is_archive := false
+	if v := readTupleElement(interface_array, 3); v != nil {
+		is_archive, ok = v.(bool)
+		if !ok { return nil, errors.New("SourceData::invalid data(3)") }
+	}
+
 	return &SourceData{
 		Name: string(name_bytes),
 		Data: data_byte,
 		IsCompressed: is_compressed,
+		IsArchive: is_archive,
+		BlobHash: blob_hash,
 	}, nil
 }
 
@@ -127,30 +256,15 @@ func MakeExecutionSettingFromTuple(tupled interface{}) (*ExecutionSetting, error
 	if tupled == nil { return nil, nil }
 	interface_array, ok := tupled.([]interface{})
 	if !ok { return nil, errors.New("ExecutionSetting::invalid data(total)") }
-	if len(interface_array) != 4 { return nil, errors.New("ExecutionSetting::invalid data(num of lement)") }
+	if len(interface_array) < 4 || len(interface_array) > 13 { return nil, errors.New("ExecutionSetting::invalid data(num of lement)") }
 
 	//
 	command_line_bytes, ok := interface_array[0].([]byte)
 	if !ok { return nil, errors.New("ExecutionSetting::invalid data(0)") }
 
 	//
-	structured_commands_array, ok := interface_array[1].([]interface{})
-	if !ok { return nil, errors.New("ExecutionSetting::invalid data(1)") }
-
-	structured_commands := make([][]string, len(structured_commands_array))
-	for i, structured_command_array := range structured_commands_array {
-		strings_array, ok := structured_command_array.([]interface{})
-		if !ok { return nil, errors.New("ExecutionSetting::invalid data(1) in") }
-
-		commands := make([]string, len(strings_array))
-		for j, string_array := range strings_array {
-			string_bytes, ok := string_array.([]byte)
-			if !ok { return nil, errors.New("ExecutionSetting::invalid data(0) in bytes") }
-
-			commands[j] = string(string_bytes)
-		}
-		structured_commands[i] = commands
-	}
+	structured_commands, err := makeStructuredCommandsFromTuple(interface_array[1])
+	if err != nil { return nil, errors.New("ExecutionSetting::invalid data(1) " + err.Error()) }
 
 	//
 	cpu_time_limit, ok := readUInt(interface_array[2])
@@ -160,12 +274,84 @@ func MakeExecutionSettingFromTuple(tupled interface{}) (*ExecutionSetting, error
 	memory_bytes_limit, ok := readUInt(interface_array[3])
 	if !ok { return nil, errors.New("ExecutionSetting::invalid data(3)") }
 
+	//
+	report_file_changes := false
+	if v := readTupleElement(interface_array, 4); v != nil {
+		report_file_changes, ok = v.(bool)
+		if !ok { return nil, errors.New("ExecutionSetting::invalid data(4)") }
+	}
+
+	//
+	var wall_time_limit uint64 = 0
+	if v := readTupleElement(interface_array, 5); v != nil {
+		wall_time_limit, ok = readUInt(v)
+		if !ok { return nil, errors.New("ExecutionSetting::invalid data(5)") }
+	}
+
+	//
+	var cpu_time_limit_ms uint64 = 0
+	if v := readTupleElement(interface_array, 6); v != nil {
+		cpu_time_limit_ms, ok = readUInt(v)
+		if !ok { return nil, errors.New("ExecutionSetting::invalid data(6)") }
+	}
+
+	//
+	var wall_time_limit_ms uint64 = 0
+	if v := readTupleElement(interface_array, 7); v != nil {
+		wall_time_limit_ms, ok = readUInt(v)
+		if !ok { return nil, errors.New("ExecutionSetting::invalid data(7)") }
+	}
+
+	//
+	var stdout_limit_bytes uint64 = 0
+	if v := readTupleElement(interface_array, 8); v != nil {
+		stdout_limit_bytes, ok = readUInt(v)
+		if !ok { return nil, errors.New("ExecutionSetting::invalid data(8)") }
+	}
+
+	//
+	var stderr_limit_bytes uint64 = 0
+	if v := readTupleElement(interface_array, 9); v != nil {
+		stderr_limit_bytes, ok = readUInt(v)
+		if !ok { return nil, errors.New("ExecutionSetting::invalid data(9)") }
+	}
+
+	//
+	override_limits := false
+	if v := readTupleElement(interface_array, 10); v != nil {
+		override_limits, ok = v.(bool)
+		if !ok { return nil, errors.New("ExecutionSetting::invalid data(10)") }
+	}
+
+	//
+	var stack_limit_bytes uint64 = 0
+	if v := readTupleElement(interface_array, 11); v != nil {
+		stack_limit_bytes, ok = readUInt(v)
+		if !ok { return nil, errors.New("ExecutionSetting::invalid data(11)") }
+	}
+
+	//
+	is_stack_same_as_memory := false
+	if v := readTupleElement(interface_array, 12); v != nil {
+		is_stack_same_as_memory, ok = v.(bool)
+		if !ok { return nil, errors.New("ExecutionSetting::invalid data(12)") }
+	}
+
 	//
 	return &ExecutionSetting{
 		CommandLine: string(command_line_bytes),
 		StructuredCommand: structured_commands,
 		CpuTimeLimit: cpu_time_limit,
 		MemoryBytesLimit: memory_bytes_limit,
+		ReportFileChanges: report_file_changes,
+		WallTimeLimit: wall_time_limit,
+		CpuTimeLimitMs: cpu_time_limit_ms,
+		WallTimeLimitMs: wall_time_limit_ms,
+		StdoutLimitBytes: stdout_limit_bytes,
+		StderrLimitBytes: stderr_limit_bytes,
+		OverrideLimits: override_limits,
+		StackLimitBytes: stack_limit_bytes,
+		IsStackSameAsMemory: is_stack_same_as_memory,
 	}, nil
 }
 
@@ -190,12 +376,36 @@ func MakeBuildInstructionFromTuple(tupled interface{}) (*BuildInstruction, error
 }
 
 
+// [[key], [key, value], ...]
+func makeStructuredCommandsFromTuple(tupled interface{}) ([][]string, error) {
+	structured_commands_array, ok := tupled.([]interface{})
+	if !ok { return nil, errors.New("(total)") }
+
+	structured_commands := make([][]string, len(structured_commands_array))
+	for i, structured_command_array := range structured_commands_array {
+		strings_array, ok := structured_command_array.([]interface{})
+		if !ok { return nil, errors.New("in") }
+
+		commands := make([]string, len(strings_array))
+		for j, string_array := range strings_array {
+			string_bytes, ok := string_array.([]byte)
+			if !ok { return nil, errors.New("in bytes") }
+
+			commands[j] = string(string_bytes)
+		}
+		structured_commands[i] = commands
+	}
+
+	return structured_commands, nil
+}
+
+
 // ========================================
 func MakeInputFromTuple(tupled interface{}) (*Input, error) {
 	if tupled == nil { return nil, nil }
 	interface_array, ok := tupled.([]interface{})
 	if !ok { return nil, errors.New("Input::invalid data(total)") }
-	if len(interface_array) != 2 { return nil, errors.New("Input::invalid data(num of lement)") }
+	if len(interface_array) < 2 || len(interface_array) > 6 { return nil, errors.New("Input::invalid data(num of lement)") }
 
 	//
 	stdin, err := MakeSourceDataFromTuple(interface_array[0])
@@ -205,9 +415,42 @@ func MakeInputFromTuple(tupled interface{}) (*Input, error) {
 	run_setting, err := MakeExecutionSettingFromTuple(interface_array[1])
 	if err != nil { return nil, errors.New("Input::invalid data(1)") }
 
+	// optional
+	expected, err := MakeSourceDataFromTuple(readTupleElement(interface_array, 2))
+	if err != nil { return nil, errors.New("Input::invalid data(2)") }
+
+	// optional
+	comparator, err := MakeOutputComparatorFromTuple(readTupleElement(interface_array, 3))
+	if err != nil { return nil, errors.New("Input::invalid data(3)") }
+
+	// optional
+	var args [][]string = nil
+	if v := readTupleElement(interface_array, 4); v != nil {
+		args, err = makeStructuredCommandsFromTuple(v)
+		if err != nil { return nil, errors.New("Input::invalid data(4) " + err.Error()) }
+	}
+
+	// optional
+	var files []*SourceData = nil
+	if v := readTupleElement(interface_array, 5); v != nil {
+		files_array, ok := v.([]interface{})
+		if !ok { return nil, errors.New("Input::invalid data(5)") }
+
+		for _, file_interface := range files_array {
+			file, err := MakeSourceDataFromTuple(file_interface)
+			if err != nil || file == nil { return nil, errors.New("Input::invalid data(5) in") }
+
+			files = append(files, file)
+		}
+	}
+
 	return &Input{
 		stdin: stdin,
 		setting: run_setting,
+		expected: expected,
+		comparator: comparator,
+		args: args,
+		files: files,
 	}, nil
 }
 
@@ -217,7 +460,7 @@ func MakeRunInstructionFromTuple(tupled interface{}) (*RunInstruction, error) {
 	if tupled == nil { return nil, nil }
 	interface_array, ok := tupled.([]interface{})
 	if !ok { return nil, errors.New("RunInstruction::invalid data(total)") }
-	if len(interface_array) != 1 { return nil, errors.New("RunInstruction::invalid data(num of lement)") }
+	if len(interface_array) < 1 || len(interface_array) > 3 { return nil, errors.New("RunInstruction::invalid data(num of lement)") }
 
 	inputs_array, ok := interface_array[0].([]interface{})
 	if !ok { return nil, errors.New("RunInstruction::invalid data(0)") }
@@ -231,8 +474,99 @@ func MakeRunInstructionFromTuple(tupled interface{}) (*RunInstruction, error) {
 		inputs[i] = *input
 	}
 
+	// optional
+	var subtasks []*Subtask = nil
+	if subtasks_interface := readTupleElement(interface_array, 1); subtasks_interface != nil {
+		subtasks_array, ok := subtasks_interface.([]interface{})
+		if !ok { return nil, errors.New("RunInstruction::invalid data(1)") }
+
+		for _, subtask_interface := range subtasks_array {
+			subtask, err := MakeSubtaskFromTuple(subtask_interface, len(inputs))
+			if err != nil { return nil, err }
+
+			subtasks = append(subtasks, subtask)
+		}
+	}
+
+	// optional
+	policy, err := MakeExecutionPolicyFromTuple(readTupleElement(interface_array, 2))
+	if err != nil { return nil, err }
+
 	return &RunInstruction{
 		Inputs: inputs,
+		Subtasks: subtasks,
+		Policy: policy,
+	}, nil
+}
+
+
+// ========================================
+func MakeExecutionPolicyFromTuple(tupled interface{}) (*ExecutionPolicy, error) {
+	if tupled == nil { return nil, nil }
+	interface_array, ok := tupled.([]interface{})
+	if !ok { return nil, errors.New("ExecutionPolicy::invalid data(total)") }
+	if len(interface_array) != 2 { return nil, errors.New("ExecutionPolicy::invalid data(num of lement)") }
+
+	//
+	kind, ok := readUInt(interface_array[0])
+	if !ok { return nil, errors.New("ExecutionPolicy::invalid data(0)") }
+	if kind > uint64(StopAfterFailuresPolicy) { return nil, errors.New("ExecutionPolicy::invalid data(0) unknown kind") }
+
+	//
+	max_failures, ok := readUInt(interface_array[1])
+	if !ok { return nil, errors.New("ExecutionPolicy::invalid data(1)") }
+	if ExecutionPolicyKind(kind) == StopAfterFailuresPolicy && max_failures == 0 {
+		return nil, errors.New("ExecutionPolicy::invalid data(1) must be greater than 0")
+	}
+
+	return &ExecutionPolicy{
+		Kind: ExecutionPolicyKind(kind),
+		MaxFailures: max_failures,
+	}, nil
+}
+
+
+// ========================================
+func MakeJudgeProgramFromTuple(tupled interface{}) (*JudgeProgram, error) {
+	if tupled == nil { return nil, nil }
+	interface_array, ok := tupled.([]interface{})
+	if !ok { return nil, errors.New("JudgeProgram::invalid data(total)") }
+	if len(interface_array) != 5 { return nil, errors.New("JudgeProgram::invalid data(num of lement)") }
+
+	//
+	proc_id, ok := readUInt(interface_array[0])
+	if !ok { return nil, errors.New("JudgeProgram::invalid data(0)") }
+
+	//
+	proc_version_bytes, ok := interface_array[1].([]byte)
+	if !ok { return nil, errors.New("JudgeProgram::invalid data(1)") }
+
+	//
+	sources_interface_array, ok := interface_array[2].([]interface{})
+	if !ok { return nil, errors.New("JudgeProgram::invalid data(2)") }
+	var sources []*SourceData
+	for _, source_interface := range sources_interface_array {
+		source, err := MakeSourceDataFromTuple(source_interface)
+		if err != nil { return nil, err }
+
+		sources = append(sources, source)
+	}
+
+	//
+	bi, err := MakeBuildInstructionFromTuple(interface_array[3])
+	if err != nil { return nil, err }
+
+	//
+	run_setting, err := MakeExecutionSettingFromTuple(interface_array[4])
+	if err != nil { return nil, err }
+	if run_setting == nil { return nil, errors.New("JudgeProgram::invalid data(4) setting is required") }
+
+	return &JudgeProgram{
+		ProcId: proc_id,
+		ProcVersion: string(proc_version_bytes),
+		Sources: sources,
+		BuildInst: bi,
+		RunSetting: run_setting,
 	}, nil
 }
 
@@ -242,7 +576,7 @@ func MakeTicketFromTuple(tupled interface{}) (*Ticket, error) {
 	if tupled == nil { return nil, nil }
 	interface_array, ok := tupled.([]interface{})
 	if !ok { return nil, errors.New("Ticket::invalid data(total)") }
-	if len(interface_array) != 6 { return nil, errors.New("Ticket::invalid data(num of lement)") }
+	if len(interface_array) < 6 || len(interface_array) > 10 { return nil, errors.New("Ticket::invalid data(num of lement)") }
 
 	//
 	base_name_bytes, ok := interface_array[0].([]byte)
@@ -275,6 +609,36 @@ func MakeTicketFromTuple(tupled interface{}) (*Ticket, error) {
 	ri, err := MakeRunInstructionFromTuple(interface_array[5])
 	if err != nil { return nil, err }
 
+	// optional
+	checker, err := MakeJudgeProgramFromTuple(readTupleElement(interface_array, 6))
+	if err != nil { return nil, err }
+
+	// optional
+	interactor, err := MakeJudgeProgramFromTuple(readTupleElement(interface_array, 7))
+	if err != nil { return nil, err }
+
+	// optional
+	var artifacts []string = nil
+	if v := readTupleElement(interface_array, 8); v != nil {
+		patterns_array, ok := v.([]interface{})
+		if !ok { return nil, errors.New("Ticket::invalid data(8)") }
+
+		for _, pattern_interface := range patterns_array {
+			pattern_bytes, ok := pattern_interface.([]byte)
+			if !ok { return nil, errors.New("Ticket::invalid data(8) in") }
+			if err := validateArtifactPattern(string(pattern_bytes)); err != nil { return nil, err }
+
+			artifacts = append(artifacts, string(pattern_bytes))
+		}
+	}
+
+	// optional
+	use_result_cache := false
+	if v := readTupleElement(interface_array, 9); v != nil {
+		use_result_cache, ok = v.(bool)
+		if !ok { return nil, errors.New("Ticket::invalid data(9)") }
+	}
+
 	//
 	return &Ticket{
 		BaseName: string(base_name_bytes),
@@ -283,5 +647,9 @@ func MakeTicketFromTuple(tupled interface{}) (*Ticket, error) {
 		Sources: sources,
 		BuildInst: bi,
 		RunInst: ri,
+		Checker: checker,
+		Interactor: interactor,
+		Artifacts: artifacts,
+		UseResultCache: use_result_cache,
 	}, nil
 }
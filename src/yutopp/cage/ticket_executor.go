@@ -13,7 +13,10 @@ package torigoya
 import(
 	"log"
 	"fmt"
+	"bytes"
 	"errors"
+	"time"
+	"sync"
 	"path/filepath"
 )
 
@@ -30,6 +33,14 @@ const (
 	CompileMode = iota
 	LinkMode
 	RunMode
+
+	// results of judge programs
+	CheckerCompileMode
+	CheckerLinkMode
+	CheckerRunMode
+	InteractorCompileMode
+	InteractorLinkMode
+	InteractorRunMode
 )
 
 var (
@@ -42,6 +53,77 @@ var (
 func (ctx *Context) ExecTicket(
 	ticket				*Ticket,
 	callback			invokeResultRecieverCallback,
+) error {
+	// sources which reference blobs must be resolved before everything
+	if err := resolveBlobReferences(ctx.blobStore, collectTicketSources(ticket)); err != nil {
+		return err
+	}
+
+	//
+	if ctx.historyStore == nil {
+		return ctx.execTicketWithCache(ticket, callback)
+	}
+
+	//
+	record := makeHistoryRecord(ticket)
+	err := ctx.execTicketWithCache(ticket, makeHistoryCollector(record, callback))
+
+	record.FinishedAt = time.Now()
+	if err != nil {
+		record.SystemErrorMessage = err.Error()
+	}
+	if err := ctx.historyStore.Record(record); err != nil {
+		log.Printf("Failed to record history of %s (%v)\n", ticket.BaseName, err)
+	}
+
+	return err
+}
+
+// results of the same ticket are replayed if the ticket requested
+func (ctx *Context) execTicketWithCache(
+	ticket				*Ticket,
+	callback			invokeResultRecieverCallback,
+) error {
+	if !ticket.UseResultCache || ctx.resultCache == nil {
+		return ctx.execTicket(ticket, callback)
+	}
+
+	//
+	proc_profile, err := ctx.procConfTable.Find(ticket.ProcId, ticket.ProcVersion)
+	if err != nil {
+		return err
+	}
+
+	key, err := makeResultCacheKey(ticket, proc_profile)
+	if err != nil {
+		log.Printf("Failed to make the result cache key of %s (%v)\n", ticket.BaseName, err)
+		return ctx.execTicket(ticket, callback)
+	}
+
+	if frames, ok := ctx.resultCache.load(key); ok {
+		log.Printf("$$$$$$$$$$ ticket: cache hit => %s (%s)\n", ticket.BaseName, key)
+		replayCachedFrames(frames, callback)
+		return nil
+	}
+
+	//
+	var frames []*cachedFrame
+	if err := ctx.execTicket(ticket, makeFrameRecorder(&frames, callback)); err != nil {
+		return err
+	}
+
+	if isCacheableResult(frames) {
+		if err := ctx.resultCache.store(key, frames); err != nil {
+			log.Printf("Failed to store the result cache of %s (%v)\n", ticket.BaseName, err)
+		}
+	}
+
+	return nil
+}
+
+func (ctx *Context) execTicket(
+	ticket				*Ticket,
+	callback			invokeResultRecieverCallback,
 ) error {
 	log.Printf("$$$$$$$$$$ START ticket => %s\n", ticket.BaseName)
 	defer log.Printf("$$$$$$$$$$ FINISH ticket  => %s\n", ticket.BaseName)
@@ -51,19 +133,63 @@ func (ctx *Context) ExecTicket(
 	if err != nil {
 		return err
 	}
+	if err := ctx.checkResourceControl(proc_profile); err != nil {
+		return err
+	}
 
 	//
-	if err := ctx.execManagedBuild(proc_profile, ticket.BaseName, ticket.Sources, ticket.BuildInst, callback); err != nil {
-		if err == buildFailedError {
+	build_err := ctx.execManagedBuild(ticket.ProcId, ticket.ProcVersion, proc_profile, ticket.BaseName, ticket.Sources, ticket.BuildInst, callback)
+	if build_err == nil || build_err == buildFailedError {
+		// files are also collected when the build was failed (e.g. logs)
+		if proc_profile.IsBuildRequired {
+			if err := ctx.collectArtifacts(ticket.BaseName, ticket.Artifacts, CompileMode, 0, callback); err != nil {
+				return err
+			}
+		}
+	}
+	if build_err != nil {
+		if build_err == buildFailedError {
 			return nil
 		} else {
-			return err
+			return build_err
 		}
 	}
 	//
 
+	// checker
+	var checker *preparedJudgeProgram = nil
+	if ticket.Checker != nil {
+		checker, err = ctx.prepareJudgeProgram(
+			ticket.Checker,
+			ticket.BaseName + "_checker",
+			CheckerCompileMode,
+			CheckerLinkMode,
+			CheckerRunMode,
+			callback,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	// interactor
+	var interactor *preparedJudgeProgram = nil
+	if ticket.Interactor != nil {
+		interactor, err = ctx.prepareJudgeProgram(
+			ticket.Interactor,
+			ticket.BaseName + "_interactor",
+			InteractorCompileMode,
+			InteractorLinkMode,
+			InteractorRunMode,
+			callback,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
 	// run
-	if errs := ctx.execManagedRun(proc_profile,	ticket.BaseName, ticket.Sources, ticket.RunInst, callback); errs != nil {
+	if errs := ctx.execManagedRun(proc_profile,	ticket.BaseName, ticket.Sources, ticket.RunInst, checker, interactor, ticket.Artifacts, callback); errs != nil {
 		// TODO: proess error
 		var s string
 		for err := range errs {
@@ -79,6 +205,8 @@ func (ctx *Context) ExecTicket(
 
 //
 func (ctx *Context) execManagedBuild(
+	proc_id				uint64,
+	proc_version		string,
 	proc_profile		*ProcProfile,
 	base_name			string,
 	sources				[]*SourceData,
@@ -95,6 +223,29 @@ func (ctx *Context) execManagedBuild(
 
 	//
 	if proc_profile.IsBuildRequired {
+		// successful builds are replayed from the cache
+		var cache_key string
+		var frames []*cachedFrame
+		if ctx.buildCache != nil {
+			key, err := makeBuildCacheKey(proc_id, proc_version, proc_profile, sources, build_inst)
+			if err != nil {
+				return err
+			}
+
+			cached_frames, ok, err := ctx.buildCache.restore(ctx, key, base_name)
+			if err != nil {
+				log.Printf("Failed to restore the build cache of %s (%v)\n", base_name, err)
+			}
+			if ok && err == nil {
+				log.Printf("$$$$$$$$$$ build: cache hit => %s (%s)\n", base_name, key)
+				replayCachedFrames(cached_frames, callback)
+				return nil
+			}
+
+			cache_key = key
+			callback = makeFrameRecorder(&frames, callback)
+		}
+
 		// build required processor
 		if err := runAsManagedUser(func(jailed_user *JailedUserInfo) error {
 			// compile phase
@@ -136,6 +287,12 @@ func (ctx *Context) execManagedBuild(
 		}); err != nil {
 			return err
 		}
+
+		if cache_key != "" {
+			if err := ctx.buildCache.store(ctx, cache_key, base_name, frames); err != nil {
+				log.Printf("Failed to store the build cache of %s (%v)\n", base_name, err)
+			}
+		}
 	}
 
 	return nil
@@ -146,6 +303,9 @@ func (ctx *Context) execManagedRun(
 	base_name			string,
 	sources				[]*SourceData,
 	run_inst			*RunInstruction,
+	checker				*preparedJudgeProgram,
+	interactor			*preparedJudgeProgram,
+	artifact_patterns	[]string,
 	callback			invokeResultRecieverCallback,
 ) []error {
 	log.Println(">> called invokeRunCommand")
@@ -153,11 +313,6 @@ func (ctx *Context) execManagedRun(
 	log.Printf("$$$$$$$$$$ START run => %s\n", base_name)
 	defer log.Printf("$$$$$$$$$$ FINISH run  => %s\n", base_name)
 
-	//
-	user_dir_path := ctx.makeUserDirName(base_name)
-	user_home_path := ctx.jailedUserDir
-	bin_base_path := filepath.Join(ctx.basePath, "bin")
-
 	// if it is build NOT required processor, sources have not been mapped yet
 	if ! proc_profile.IsBuildRequired {
 		if err := runAsManagedUser(func(jailed_user *JailedUserInfo) error {
@@ -173,32 +328,198 @@ func (ctx *Context) execManagedRun(
 	}
 
 	//
-	var errs []error = nil
-	// ========================================
-	for index, input := range run_inst.Inputs {
-		// TODO: async
-		err := runAsManagedUser(func(jailed_user *JailedUserInfo) error {
-			return ctx.invokeRunCommand(
-				user_dir_path,
-				user_home_path,
-				bin_base_path,
-				jailed_user,
-
-				base_name,
-				proc_profile,
-				index,
-				&input,
-				callback,
-			)
+	var scorer *subtaskScorer = nil
+	if len(run_inst.Subtasks) > 0 {
+		scorer = newSubtaskScorer(run_inst.Subtasks, len(run_inst.Inputs))
+	}
+
+	// inputs are executed in their own copies of the workspace if they are run at the same time,
+	// or if they have data files, so that files which were left by other inputs never conflict with them
+	parallelism := ctx.runParallelism
+	is_isolated := len(run_inst.Inputs) > 1 && (parallelism > 1 || hasInputDataFiles(run_inst.Inputs))
+
+	// results are reported from multiple goroutines
+	callback = makeSerializedCallback(callback)
+
+	//
+	progress := &runProgress{ policy: run_inst.Policy, scorer: scorer }
+	runInputsConcurrently(
+		len(run_inst.Inputs),
+		parallelism,
+		progress,
+		func(index int) (float64, error) {
+			executed, err := ctx.execRunInput(proc_profile, base_name, index, &run_inst.Inputs[index], checker, interactor, artifact_patterns, is_isolated, callback)
+			if err != nil {
+				return 0, err
+			}
+			return executed.Score(), nil
+		},
+		func(index int) {
+			log.Printf("skip input %d\n", index)
+			sendResultToCallback(callback, &ExecutedResult{ Status: Skipped }, RunMode, index)
+		},
+	)
+
+	//
+	if scorer != nil && callback != nil {
+		callback(&StreamScoreSummary{
+			Summary: scorer.summary(),
 		})
+	}
 
-		if err != nil {
-			if errs == nil { errs = make([]error, 0) }
-			errs = append(errs, err)
+	return progress.errs
+}
+
+
+// failures of inputs which decide whether remaining inputs are skipped
+type runProgress struct {
+	mutex				sync.Mutex		// guards all fields
+	policy				*ExecutionPolicy
+	scorer				*subtaskScorer	// optional
+	failures			uint64
+	errs				[]error
+}
+
+// inputs which are skipped are also recorded to the scorer
+func (p *runProgress) decideSkip(index int) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	is_skipped := p.policy.shouldStop(p.failures) || (p.scorer != nil && p.scorer.shouldSkip(index))
+	if is_skipped && p.scorer != nil {
+		p.scorer.skip(index)
+	}
+	return is_skipped
+}
+
+// an input which was not fully accepted is a failure
+func (p *runProgress) record(index int, score float64, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err != nil {
+		if p.errs == nil { p.errs = make([]error, 0) }
+		p.errs = append(p.errs, err)
+	}
+	if score < 1 {
+		p.failures++
+	}
+	if p.scorer != nil {
+		p.scorer.record(index, score)
+	}
+}
+
+// inputs are started in order, and at most parallelism inputs are running at the same time.
+// skipping is decided just before each input is started (see ExecutionPolicy)
+func runInputsConcurrently(
+	num_inputs			int,
+	parallelism			int,
+	progress			*runProgress,
+	run					func(index int) (float64, error),	// returns the score of the input, 1 if it was accepted
+	skip				func(index int),
+) {
+	if parallelism < 1 { parallelism = 1 }
+
+	semaphore := make(chan bool, parallelism)
+	var wg sync.WaitGroup
+	for index := 0; index < num_inputs; index++ {
+		// wait for a slot before deciding to skip, so that finished results are taken into account
+		semaphore <- true
+
+		if progress.decideSkip(index) {
+			<-semaphore
+			skip(index)
+			continue
+		}
+
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			score, err := run(index)
+			progress.record(index, score, err)
+		}(index)
+	}
+	wg.Wait()
+}
+
+
+func (ctx *Context) execRunInput(
+	proc_profile		*ProcProfile,
+	base_name			string,
+	index				int,
+	input				*Input,
+	checker				*preparedJudgeProgram,
+	interactor			*preparedJudgeProgram,
+	artifact_patterns	[]string,
+	is_isolated			bool,
+	callback			invokeResultRecieverCallback,
+) (executed *StreamExecutedResult, err error) {
+	//
+	if is_isolated {
+		isolated_base_name := makeIsolatedBaseName(base_name, index)
+		if err := ctx.cloneWorkspace(base_name, isolated_base_name); err != nil {
+			return nil, err
+		}
+		defer ctx.removeWorkspace(isolated_base_name)
+		base_name = isolated_base_name
+
+		if checker != nil {
+			isolated_checker, err := ctx.isolateJudgeProgram(checker, index)
+			if err != nil { return nil, err }
+			defer ctx.removeWorkspace(isolated_checker.baseName)
+			checker = isolated_checker
+		}
+
+		if interactor != nil {
+			isolated_interactor, err := ctx.isolateJudgeProgram(interactor, index)
+			if err != nil { return nil, err }
+			defer ctx.removeWorkspace(isolated_interactor.baseName)
+			interactor = isolated_interactor
 		}
 	}
 
-	return errs
+	// files are collected after the execution
+	defer func() {
+		if err == nil {
+			err = ctx.collectArtifacts(base_name, artifact_patterns, RunMode, index, callback)
+		}
+	}()
+
+	if interactor != nil {
+		// the interactor decides the verdict instead of the checker
+		return ctx.invokeInteractiveRunCommand(base_name, proc_profile, index, input, interactor, callback)
+	}
+
+	//
+	user_dir_path := ctx.makeUserDirName(base_name)
+	user_home_path := ctx.jailedUserDir
+	bin_base_path := filepath.Join(ctx.basePath, "bin")
+
+	err = runAsManagedUser(func(jailed_user *JailedUserInfo) error {
+		var err error
+		executed, err = ctx.invokeRunCommand(
+			user_dir_path,
+			user_home_path,
+			bin_base_path,
+			jailed_user,
+
+			base_name,
+			proc_profile,
+			index,
+			input,
+			checker,
+			callback,
+		)
+		return err
+	})
+
+	return executed, err
+}
+
+func makeIsolatedBaseName(base_name string, index int) string {
+	return fmt.Sprintf("%s_run%d", base_name, index)
 }
 
 
@@ -235,12 +556,14 @@ func (ctx *Context) invokeCompileCommand(
 			Mode: CompileMode,
 		},
 		IsReboot: false,
+		CgroupRootPath: ctx.cgroupRootPath,
+		MaxLimits: ctx.maxLimits,
 	}
 
 	//
 	build_output_stream := make(chan *StreamOutput)
 	closed_ch := make(chan bool)
-	go sendOutputToCallback(callback, build_output_stream, CompileMode, 0, closed_ch)
+	go sendOutputToCallback(callback, build_output_stream, CompileMode, 0, nil, closed_ch)
 
 	//
 	result, err := message.invokeProcessCloner(bin_base_path, build_output_stream, base_name)
@@ -287,12 +610,14 @@ func (ctx *Context) invokeLinkCommand(
 			Mode: LinkMode,
 		},
 		IsReboot: false,
+		CgroupRootPath: ctx.cgroupRootPath,
+		MaxLimits: ctx.maxLimits,
 	}
 
 	//
 	link_output_stream := make(chan *StreamOutput)
 	closed_ch := make(chan bool)
-	go sendOutputToCallback(callback, link_output_stream, LinkMode, 0, closed_ch)
+	go sendOutputToCallback(callback, link_output_stream, LinkMode, 0, nil, closed_ch)
 
 	//
 	result, err := message.invokeProcessCloner(bin_base_path, link_output_stream, base_name)
@@ -319,17 +644,25 @@ func (ctx *Context) invokeRunCommand(
 	proc_profile		*ProcProfile,
 	index				int,
 	input				*Input,
+	checker				*preparedJudgeProgram,
 	callback			invokeResultRecieverCallback,
-) error {
+) (*StreamExecutedResult, error) {
 	log.Println(">> called invokeRunInputCommand")
 
+	setting, err := makeInputSetting(proc_profile, input)
+	if err != nil { return nil, err }
+
 	// TODO: add lock
 	// reassign base files to new user
+	var data_file_paths []string = nil
 	user_dir_path, input_path, err := ctx.reassignTarget(
 		base_name,
 		jailed_user.UserId,
 		jailed_user.GroupId,
 		func(base_directory_name string) (*string, error) {
+			data_file_paths, err = ctx.createInputDataFiles(base_directory_name, jailed_user, input)
+			if err != nil { return nil, err }
+
 			if input.stdin != nil {
 				// stdin exists
 
@@ -347,12 +680,13 @@ func (ctx *Context) invokeRunCommand(
 			}
 		},
 	)
-	if err != nil { return err }
+	defer removeDataFiles(data_file_paths)
+	if err != nil { return nil, err }
 
 	//
 	var stdin_path *string = nil
 	if input.stdin != nil {
-		if input_path == nil { return errors.New("invalid stdin file") }
+		if input_path == nil { return nil, errors.New("invalid stdin file") }
 
 		// adjust path to jailed env
 		real_user_home_path := filepath.Join(user_dir_path, user_home_path)
@@ -370,26 +704,96 @@ func (ctx *Context) invokeRunCommand(
 		Message: ExecMessage{
 			Profile: proc_profile,
 			StdinFilePath: stdin_path,
-			Setting: input.setting,
+			Setting: setting,
 			Mode: RunMode,
 		},
 		IsReboot: false,
+		CgroupRootPath: ctx.cgroupRootPath,
+		MaxLimits: ctx.maxLimits,
+	}
+
+	// stdout is kept to judge it
+	var stdout_buffer *bytes.Buffer = nil
+	if input.expected != nil || checker != nil {
+		stdout_buffer = bytes.NewBuffer(nil)
 	}
 
 	//
 	run_output_stream := make(chan *StreamOutput)
 	closed_ch := make(chan bool)
-	go sendOutputToCallback(callback, run_output_stream, RunMode, index, closed_ch)
+	go sendOutputToCallback(callback, run_output_stream, RunMode, index, stdout_buffer, closed_ch)
 
 	//
 	result, err := message.invokeProcessCloner(bin_base_path, run_output_stream, base_name)
 
 	//
 	<-closed_ch
-	if err != nil { return err }
-	sendResultToCallback(callback, result, RunMode, index)
+	if err != nil { return nil, err }
 
-	return nil
+	//
+	var verdict *JudgeVerdict = nil
+	if !result.IsFailed() {
+		if checker != nil {
+			verdict, err = ctx.invokeCheckerCommand(checker, index, input, stdout_buffer.Bytes(), callback)
+			if err != nil { return nil, err }
+
+		} else if input.expected != nil {
+			expected_content, err := convertSourceToContent(input.expected)
+			if err != nil { return nil, err }
+
+			verdict = input.comparator.Compare(expected_content.Data, stdout_buffer.Bytes())
+		}
+	}
+	return sendJudgedResultToCallback(callback, result, verdict, RunMode, index), nil
+}
+
+
+// extra arguments of the input must be allowed by the profile
+func makeInputSetting(
+	proc_profile		*ProcProfile,
+	input				*Input,
+) (*ExecutionSetting, error) {
+	if len(input.args) == 0 {
+		return input.setting, nil
+	}
+	if input.setting == nil {
+		return nil, errors.New("input.setting is nil")
+	}
+
+	for _, arg := range input.args {
+		if err := proc_profile.Run.isValidOption(arg); err != nil {
+			return nil, err
+		}
+	}
+
+	setting := *input.setting
+	setting.StructuredCommand = append(append([][]string{}, input.setting.StructuredCommand...), input.args...)
+
+	return &setting, nil
+}
+
+func hasInputDataFiles(inputs []Input) bool {
+	for _, input := range inputs {
+		if len(input.files) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (ctx *Context) createInputDataFiles(
+	base_directory_name	string,
+	jailed_user			*JailedUserInfo,
+	input				*Input,
+) ([]string, error) {
+	if len(input.files) == 0 {
+		return nil, nil
+	}
+
+	contents, err := convertSourcesToContents(input.files)
+	if err != nil { return nil, err }
+
+	return ctx.createDataFiles(base_directory_name, jailed_user.UserId, jailed_user.GroupId, contents)
 }
 
 
@@ -448,9 +852,35 @@ type StreamExecutedResult struct {
 	Mode		int
 	Index		int
 	Result		*ExecutedResult
+	Verdict		*JudgeVerdict	// nil if output was not judged
+	IsCached	bool			// the result was replayed from the cache
 }
 func (r *StreamExecutedResult) ToTuple() []interface{} {
-	return []interface{}{ r.Mode, r.Index, r.Result.ToTuple() }
+	var verdict []interface{} = nil
+	if r.Verdict != nil {
+		verdict = r.Verdict.ToTuple()
+	}
+
+	return []interface{}{ r.Mode, r.Index, r.Result.ToTuple(), verdict, r.IsCached }
+}
+
+// the program exited normally, and its output was accepted if judged
+func (r *StreamExecutedResult) IsAccepted() bool {
+	if r.Result == nil || r.Result.IsFailed() {
+		return false
+	}
+	return r.Verdict == nil || r.Verdict.Status == Accepted
+}
+
+// 1 if accepted, the partial score given by the checker, or 0
+func (r *StreamExecutedResult) Score() float64 {
+	if r.IsAccepted() {
+		return 1
+	}
+	if r.Result == nil || r.Result.IsFailed() || r.Verdict == nil || r.Verdict.Status != PartiallyAccepted {
+		return 0
+	}
+	return r.Verdict.Score
 }
 
 
@@ -464,6 +894,7 @@ func sendOutputToCallback(
 	output_stream		chan *StreamOutput,
 	mode				int,
 	index				int,
+	captured_stdout		*bytes.Buffer,
 	closed_ch			chan bool,
 ) {
 	nil_count := 0
@@ -481,6 +912,10 @@ func sendOutputToCallback(
 			break
 		}
 
+		if out != nil && captured_stdout != nil && out.Fd == StdoutFd {
+			captured_stdout.Write(out.Buffer)
+		}
+
 		if out != nil && callback != nil {
 			callback(&StreamOutputResult{
 				Mode: mode,
@@ -499,11 +934,66 @@ func sendResultToCallback(
 	mode				int,
 	index				int,
 ) {
+	sendJudgedResultToCallback(callback, result, nil, mode, index)
+}
+
+//
+func sendJudgedResultToCallback(
+	callback			invokeResultRecieverCallback,
+	result				*ExecutedResult,
+	verdict				*JudgeVerdict,
+	mode				int,
+	index				int,
+) *StreamExecutedResult {
+	r := &StreamExecutedResult{
+		Mode: mode,
+		Index: index,
+		Result: result,
+		Verdict: verdict,
+	}
 	if callback != nil {
-		callback(&StreamExecutedResult{
-			Mode: mode,
-			Index: index,
-			Result: result,
-		})
+		callback(r)
+	}
+
+	return r
+}
+
+
+// the callback can be called from multiple goroutines
+func makeSerializedCallback(
+	callback			invokeResultRecieverCallback,
+) invokeResultRecieverCallback {
+	var mutex sync.Mutex
+	return func(v interface{}) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if callback != nil {
+			callback(v)
+		}
+	}
+}
+
+
+// observes results to fill the history record
+func makeHistoryCollector(
+	record				*HistoryRecord,
+	callback			invokeResultRecieverCallback,
+) invokeResultRecieverCallback {
+	return func(v interface{}) {
+		if r, ok := v.(*StreamExecutedResult); ok {
+			switch r.Mode {
+			case CompileMode:
+				if record.Compile != nil { record.Compile.update(r.Result) }
+			case LinkMode:
+				if record.Link != nil { record.Link.update(r.Result) }
+			case RunMode:
+				if r.Index >= 0 && r.Index < len(record.Runs) { record.Runs[r.Index].update(r.Result) }
+			}
+		}
+
+		if callback != nil {
+			callback(v)
+		}
 	}
 }
@@ -0,0 +1,260 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import (
+	"testing"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"reflect"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+
+// runs inputs and returns indices which were executed and skipped
+func runInputsForTest(
+	num_inputs			int,
+	parallelism			int,
+	progress			*runProgress,
+	run					func(index int) (float64, error),
+) ([]int, []int) {
+	var mutex sync.Mutex
+	var executed, skipped []int
+	runInputsConcurrently(
+		num_inputs,
+		parallelism,
+		progress,
+		func(index int) (float64, error) {
+			mutex.Lock()
+			executed = append(executed, index)
+			mutex.Unlock()
+			return run(index)
+		},
+		func(index int) {
+			mutex.Lock()
+			skipped = append(skipped, index)
+			mutex.Unlock()
+		},
+	)
+	sort.Ints(executed)
+	sort.Ints(skipped)
+	return executed, skipped
+}
+
+func TestUnitRunInputsStopAtFirstFailure(t *testing.T) {
+	progress := &runProgress{ policy: &ExecutionPolicy{ Kind: StopAtFirstFailurePolicy } }
+	executed, skipped := runInputsForTest(5, 1, progress, func(index int) (float64, error) {
+		if index == 1 {
+			return 0, nil
+		}
+		return 1, nil
+	})
+
+	if len(executed) != 2 || len(skipped) != 3 || skipped[0] != 2 {
+		t.Fatalf("inputs after the failure should be skipped (executed: %v, skipped: %v)", executed, skipped)
+	}
+	if progress.failures != 1 {
+		t.Fatalf("failures should be 1 (but %d)", progress.failures)
+	}
+}
+
+// inputs which are running when the policy trips are NOT cancelled
+func TestUnitRunInputsInFlightWhenPolicyStops(t *testing.T) {
+	progress := &runProgress{ policy: &ExecutionPolicy{ Kind: StopAtFirstFailurePolicy } }
+
+	// inputs 1 and 2 are finished after input 0 failed
+	failed := make(chan bool)
+	executed, skipped := runInputsForTest(6, 3, progress, func(index int) (float64, error) {
+		switch index {
+		case 0:
+			defer close(failed)
+			return 0, nil
+		case 1, 2:
+			<-failed
+			return 1, nil
+		default:
+			return 1, nil
+		}
+	})
+
+	if len(executed) != 3 || executed[0] != 0 || executed[2] != 2 {
+		t.Fatalf("inputs 0-2 should be executed (but %v)", executed)
+	}
+	if len(skipped) != 3 || skipped[0] != 3 {
+		t.Fatalf("inputs 3-5 should be skipped (but %v)", skipped)
+	}
+}
+
+// results are reported by the serialized callback from concurrent inputs
+func TestUnitRunInputsParallelCallback(t *testing.T) {
+	const num_inputs = 12
+	const parallelism = 3
+
+	var in_callback int32 = 0
+	var reported []int
+	callback := makeSerializedCallback(func(v interface{}) {
+		if atomic.AddInt32(&in_callback, 1) != 1 {
+			t.Errorf("callback was called at the same time")
+		}
+		defer atomic.AddInt32(&in_callback, -1)
+
+		if r, ok := v.(*StreamExecutedResult); ok {
+			reported = append(reported, r.Index)
+		}
+	})
+
+	// the first inputs wait until all slots are used
+	var running, max_running int32 = 0, 0
+	all_started := make(chan bool)
+	var once sync.Once
+	progress := &runProgress{ policy: &ExecutionPolicy{ Kind: RunAllPolicy } }
+	executed, skipped := runInputsForTest(num_inputs, parallelism, progress, func(index int) (float64, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&max_running)
+			if n <= m || atomic.CompareAndSwapInt32(&max_running, m, n) { break }
+		}
+		if n == parallelism {
+			once.Do(func() { close(all_started) })
+		}
+		if index < parallelism {
+			<-all_started
+		}
+
+		sendResultToCallback(callback, &ExecutedResult{ Status: Passed }, RunMode, index)
+		return 1, nil
+	})
+
+	if len(executed) != num_inputs || len(skipped) != 0 {
+		t.Fatalf("all inputs should be executed (executed: %v, skipped: %v)", executed, skipped)
+	}
+	if max_running != parallelism {
+		t.Fatalf("%d inputs should be running at the same time (but %d)", parallelism, max_running)
+	}
+
+	// results are reported in the order of completion, each input is reported once
+	sort.Ints(reported)
+	for i, index := range reported {
+		if i != index {
+			t.Fatalf("each input should be reported once (%v)", reported)
+		}
+	}
+	if len(reported) != num_inputs {
+		t.Fatalf("%d results should be reported (but %d)", num_inputs, len(reported))
+	}
+}
+
+func TestUnitCloneWorkspace(t *testing.T) {
+	sandbox, err := ioutil.TempDir("", "torigoya_workspace")
+	if err != nil { t.Fatalf("%v", err) }
+	defer os.RemoveAll(sandbox)
+
+	ctx := &Context{
+		hostUser: &user.User{ Uid: "0" },
+		sandboxDir: sandbox,
+		homeDir: "home",
+		jailedUserDir: "home/torigoya",
+	}
+
+	// original workspace
+	home := filepath.Join(ctx.makeUserDirName("base"), ctx.jailedUserDir)
+	os.MkdirAll(filepath.Join(home, "dir"), 0750)
+	ioutil.WriteFile(filepath.Join(home, "prog.out"), []byte("binary"), 0550)
+	ioutil.WriteFile(filepath.Join(home, "dir", "data.txt"), []byte("data"), 0440)
+	os.Symlink("prog.out", filepath.Join(home, "link"))
+
+	if err := ctx.cloneWorkspace("base", makeIsolatedBaseName("base", 1)); err != nil { t.Fatalf("%v", err) }
+	cloned_home := filepath.Join(ctx.makeUserDirName("base_run1"), ctx.jailedUserDir)
+
+	if b, err := ioutil.ReadFile(filepath.Join(cloned_home, "prog.out")); err != nil || string(b) != "binary" {
+		t.Fatalf("prog.out should be copied (%s, %v)", b, err)
+	}
+	if info, err := os.Stat(filepath.Join(cloned_home, "prog.out")); err != nil || info.Mode().Perm() != 0550 {
+		t.Fatalf("mode of prog.out should be kept (%v, %v)", info, err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(cloned_home, "dir", "data.txt")); err != nil || string(b) != "data" {
+		t.Fatalf("dir/data.txt should be copied (%s, %v)", b, err)
+	}
+	if link, err := os.Readlink(filepath.Join(cloned_home, "link")); err != nil || link != "prog.out" {
+		t.Fatalf("symlink should be copied as is (%s, %v)", link, err)
+	}
+
+	// the clone is independent of the original
+	os.Remove(filepath.Join(cloned_home, "dir", "data.txt"))
+	if _, err := os.Stat(filepath.Join(home, "dir", "data.txt")); err != nil {
+		t.Fatalf("the original should not be changed (%v)", err)
+	}
+
+	// the clone is removed
+	if err := ctx.removeWorkspace("base_run1"); err != nil { t.Fatalf("%v", err) }
+	if _, err := os.Stat(ctx.makeUserDirName("base_run1")); !os.IsNotExist(err) {
+		t.Fatalf("the clone should be removed (%v)", err)
+	}
+}
+
+func TestUnitMakeInputSetting(t *testing.T) {
+	proc_profile := &ProcProfile{
+		Run: PhaseDetail{
+			Command: "./prog.out",
+			AllowedCommandLine: map[string]SelectableCommand{
+				"--fast": SelectableCommand{},
+			},
+		},
+	}
+	base := &ExecutionSetting{
+		StructuredCommand: [][]string{ []string{ "--base" } },
+		CpuTimeLimit: 1,
+		MemoryBytesLimit: 512,
+	}
+
+	// the setting is used as is if there are no arguments
+	setting, err := makeInputSetting(proc_profile, &Input{ setting: base })
+	if err != nil || setting != base {
+		t.Fatalf("the setting should be used as is (%v, %v)", setting, err)
+	}
+
+	// arguments are appended to the copy of the setting
+	setting, err = makeInputSetting(proc_profile, &Input{ setting: base, args: [][]string{ []string{ "--fast" } } })
+	if err != nil { t.Fatalf("%v", err) }
+	if !reflect.DeepEqual(setting.StructuredCommand, [][]string{ []string{ "--base" }, []string{ "--fast" } }) {
+		t.Fatalf("arguments should be appended (%v)", setting.StructuredCommand)
+	}
+	if setting.CpuTimeLimit != 1 || setting.MemoryBytesLimit != 512 {
+		t.Fatalf("limits should be kept (%v)", setting)
+	}
+	if !reflect.DeepEqual(base.StructuredCommand, [][]string{ []string{ "--base" } }) {
+		t.Fatalf("the original setting should not be modified (%v)", base.StructuredCommand)
+	}
+
+	// arguments which are not allowed
+	if _, err := makeInputSetting(proc_profile, &Input{ setting: base, args: [][]string{ []string{ "--slow" } } }); err == nil {
+		t.Fatalf("arguments which are not allowed should be rejected")
+	}
+
+	// no setting
+	if _, err := makeInputSetting(proc_profile, &Input{ args: [][]string{ []string{ "--fast" } } }); err == nil {
+		t.Fatalf("arguments without the setting should be rejected")
+	}
+}
+
+func TestUnitHasInputDataFiles(t *testing.T) {
+	if hasInputDataFiles([]Input{ Input{}, Input{} }) {
+		t.Fatalf("inputs without data files")
+	}
+	if !hasInputDataFiles([]Input{ Input{}, Input{ files: []*SourceData{ &SourceData{ Name: "data.txt" } } } }) {
+		t.Fatalf("an input has data files")
+	}
+}
@@ -0,0 +1,72 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+package torigoya
+
+import (
+	"testing"
+)
+
+
+func TestUnitExecutionPolicyShouldStop(t *testing.T) {
+	cases := []struct {
+		policy				*ExecutionPolicy
+		failures			uint64
+		expected			bool
+	}{
+		{ nil, 0, false },
+		{ nil, 100, false },
+		{ &ExecutionPolicy{ Kind: RunAllPolicy }, 100, false },
+		{ &ExecutionPolicy{ Kind: StopAtFirstFailurePolicy }, 0, false },
+		{ &ExecutionPolicy{ Kind: StopAtFirstFailurePolicy }, 1, true },
+		{ &ExecutionPolicy{ Kind: StopAfterFailuresPolicy, MaxFailures: 3 }, 2, false },
+		{ &ExecutionPolicy{ Kind: StopAfterFailuresPolicy, MaxFailures: 3 }, 3, true },
+		{ &ExecutionPolicy{ Kind: StopAfterFailuresPolicy, MaxFailures: 3 }, 4, true },
+	}
+
+	for i, c := range cases {
+		if c.policy.shouldStop(c.failures) != c.expected {
+			t.Fatalf("case %d: shouldStop(%d) should be %t", i, c.failures, c.expected)
+		}
+	}
+}
+
+func TestUnitMakeExecutionPolicyFromTuple(t *testing.T) {
+	// not given
+	if p, err := MakeExecutionPolicyFromTuple(nil); p != nil || err != nil {
+		t.Fatalf("nil policy should be accepted (%v, %v)", p, err)
+	}
+
+	p, err := MakeExecutionPolicyFromTuple([]interface{}{ uint64(2), int64(5) })
+	if err != nil { t.Fatalf("%v", err) }
+	if p.Kind != StopAfterFailuresPolicy || p.MaxFailures != 5 {
+		t.Fatalf("unexpected policy (%v)", p)
+	}
+
+	p, err = MakeExecutionPolicyFromTuple([]interface{}{ int64(1), int64(0) })
+	if err != nil { t.Fatalf("%v", err) }
+	if p.Kind != StopAtFirstFailurePolicy {
+		t.Fatalf("unexpected policy (%v)", p)
+	}
+
+	invalids := []interface{}{
+		"policy",
+		[]interface{}{ uint64(1) },
+		[]interface{}{ uint64(1), uint64(0), uint64(0) },
+		[]interface{}{ "1", uint64(0) },
+		[]interface{}{ uint64(3), uint64(0) },
+		[]interface{}{ uint64(1) << 63, uint64(0) },
+		[]interface{}{ uint64(2), uint64(0) },
+		[]interface{}{ uint64(2), "5" },
+	}
+	for i, v := range invalids {
+		if _, err := MakeExecutionPolicyFromTuple(v); err == nil {
+			t.Fatalf("case %d: %v should be rejected", i, v)
+		}
+	}
+}
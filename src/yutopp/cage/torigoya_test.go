@@ -283,6 +283,8 @@ int main() {
 }
 `),
 			false,
+			false,
+			"",
 		},
 	}
 
@@ -302,7 +304,7 @@ int main() {
 	}
 
 	// build
-	if err := ctx.execManagedBuild(&proc_profile, base_name, sources, build_inst, nil); err != nil {
+	if err := ctx.execManagedBuild(0, "test", &proc_profile, base_name, sources, build_inst, nil); err != nil {
 		t.Errorf(err.Error())
 		return
 	}
@@ -336,6 +338,8 @@ int main() {
 }
 `),
 			false,
+			false,
+			"",
 		},
 	}
 
@@ -367,6 +371,8 @@ int main() {
 					"hoge.in",
 					[]byte("100"),
 					false,
+					false,
+					"",
 				},
 				setting: &ExecutionSetting{
 					CpuTimeLimit: 10,
@@ -458,6 +464,8 @@ int main() {
 }
 `),
 					false,
+					false,
+					"",
 				},
 			}
 
@@ -489,6 +497,8 @@ int main() {
 							"hoge.in",
 							[]byte("100"),
 							false,
+							false,
+							"",
 						},
 						setting: &ExecutionSetting{
 							CpuTimeLimit: 10,
@@ -588,6 +598,8 @@ int main() {
 }
 `),
 					false,
+					false,
+					"",
 				},
 			}
 
@@ -619,6 +631,8 @@ int main() {
 							"hoge.in",
 							[]byte("100"),
 							false,
+							false,
+							"",
 						},
 						setting: &ExecutionSetting{
 							CpuTimeLimit: 10,
@@ -699,6 +713,8 @@ int main() {
 }
 `),
 			false,
+			false,
+			"",
 		},
 	}
 
@@ -796,6 +812,8 @@ int main() {
 }
 `),
 			false,
+			false,
+			"",
 		},
 	}
 
@@ -888,6 +906,8 @@ int main() {
 }
 `),
 			false,
+			false,
+			"",
 		},
 	}
 
@@ -946,7 +966,7 @@ int main() {
 		run: map[int]*test_result_unit{
 			0: &test_result_unit{
 				result: &ExecutedResult {
-					Status: Error,
+					Status: WallTimeLimit,
 				},
 			},
 		},
@@ -981,6 +1001,8 @@ int main() {
 }
 `),
 			false,
+			false,
+			"",
 		},
 	}
 
@@ -1074,6 +1096,8 @@ int main() {
 }
 `),
 			false,
+			false,
+			"",
 		},
 	}
 
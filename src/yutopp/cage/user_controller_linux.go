@@ -15,25 +15,32 @@ import(
 	"strconv"
 	"strings"
 	"unicode"
+	"sync"
 	"errors"
 
 	"github.com/jmcvetta/randutil"
 )
 
 
+// useradd/userdel fail if they are called at the same time
+var userDatabaseMutex sync.Mutex
+
 func CreateUser(user_name string) (int, int, error) {
 	println("==> " + user_name)
 
+	userDatabaseMutex.Lock()
+	defer userDatabaseMutex.Unlock()
+
 	// create user
 	user_craete_command := exec.Command("useradd", "--no-create-home", user_name)
-	if err := user_craete_command.Run(); err != nil {
+	if err := runCommand(user_craete_command); err != nil {
 		return 0, 0, errors.New("Failed to useradd : " + err.Error())
 	}
 
 	// get uid/gid
-	user_id_data, err := exec.Command("id", "--user", user_name).Output()
+	user_id_data, err := commandOutput(exec.Command("id", "--user", user_name))
 	if err != nil { return 0, 0, err }
-	group_id_data, err := exec.Command("id", "--group", user_name).Output()
+	group_id_data, err := commandOutput(exec.Command("id", "--group", user_name))
 	if err != nil { return 0, 0, err }
 
 	// convert ids from string to int
@@ -51,8 +58,11 @@ func CreateUser(user_name string) (int, int, error) {
 
 
 func DeleteUser(user_name string) error {
+	userDatabaseMutex.Lock()
+	defer userDatabaseMutex.Unlock()
+
 	user_delete_command := exec.Command("userdel", user_name)
-	if err := user_delete_command.Run(); err != nil {
+	if err := runCommand(user_delete_command); err != nil {
 		return errors.New("Failed to userdel : " + err.Error())
 	}
 
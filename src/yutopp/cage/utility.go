@@ -31,3 +31,26 @@ func readUInt(v interface{}) (uint64, bool) {
 		return 0, false
 	}
 }
+
+func readFloat(v interface{}) (float64, bool) {
+	switch v.(type) {
+	case float64:
+		return v.(float64), true
+	case float32:
+		return float64(v.(float32)), true
+	case int64:
+		return float64(v.(int64)), true
+	case uint64:
+		return float64(v.(uint64)), true
+	default:
+		return 0, false
+	}
+}
+
+// optional elements can be omitted from the tail of tuples
+func readTupleElement(interface_array []interface{}, index int) interface{} {
+	if index < len(interface_array) {
+		return interface_array[index]
+	}
+	return nil
+}
@@ -0,0 +1,329 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import(
+	"fmt"
+	"errors"
+	"path"
+)
+
+
+// ========================================
+// a phase which would be executed. paths are relative to HOME in the jail
+type ValidatedPhase struct {
+	Mode				int
+	Index				int
+	Args				[]string
+	Env					map[string]string
+	CpuTimeLimitMs		uint64
+	WallTimeLimitMs		uint64
+	MemoryBytesLimit	uint64
+	FileSizeLimit		uint64
+	StdoutLimitBytes	uint64
+	StderrLimitBytes	uint64
+	StackLimitBytes		uint64
+	Umask				int
+	StdinFile			string		// empty if stdin is not given or connected to a pipe
+	Files				[]string	// read only files which are placed only for this phase
+}
+
+func (p *ValidatedPhase) ToTuple() []interface{} {
+	return []interface{}{
+		p.Mode,
+		p.Index,
+		p.Args,
+		p.Env,
+		p.CpuTimeLimitMs,
+		p.MemoryBytesLimit,
+		p.FileSizeLimit,
+		p.Umask,
+		p.StdinFile,
+		p.Files,
+		p.WallTimeLimitMs,
+		p.StdoutLimitBytes,
+		p.StderrLimitBytes,
+		p.StackLimitBytes,
+	}
+}
+
+
+// Field points the wrong part of the ticket (e.g. "run_inst.inputs[2].args")
+type ValidationError struct {
+	Field				string
+	Message				string
+}
+
+func (e *ValidationError) ToTuple() []interface{} {
+	return []interface{}{ e.Field, e.Message }
+}
+
+
+//
+type ValidationReport struct {
+	Sources				[]string	// files which are placed under HOME of the program
+	CheckerSources		[]string	// nil if the checker is not given
+	InteractorSources	[]string	// nil if the interactor is not given
+	Phases				[]*ValidatedPhase
+	Errors				[]*ValidationError
+}
+
+func (r *ValidationReport) IsValid() bool {
+	return len(r.Errors) == 0
+}
+
+func (r *ValidationReport) ToTuple() []interface{} {
+	phases := make([]interface{}, len(r.Phases))
+	for i, p := range r.Phases {
+		phases[i] = p.ToTuple()
+	}
+	errs := make([]interface{}, len(r.Errors))
+	for i, e := range r.Errors {
+		errs[i] = e.ToTuple()
+	}
+
+	return []interface{}{ r.IsValid(), r.Sources, r.CheckerSources, r.InteractorSources, phases, errs }
+}
+
+func (r *ValidationReport) addError(field string, err error) {
+	r.Errors = append(r.Errors, &ValidationError{
+		Field: field,
+		Message: err.Error(),
+	})
+}
+
+func (r *ValidationReport) addPhase(
+	field				string,
+	proc_profile		*ProcProfile,
+	max_limits			*PhaseLimits,
+	exec_mode			int,
+	reported_mode		int,
+	index				int,
+	setting				*ExecutionSetting,
+	stdin_file			string,
+	files				[]string,
+) {
+	plan, err := makeExecPlan(proc_profile, exec_mode, setting, max_limits)
+	if err != nil {
+		r.addError(field, err)
+		return
+	}
+
+	r.Phases = append(r.Phases, &ValidatedPhase{
+		Mode: reported_mode,
+		Index: index,
+		Args: plan.args,
+		Env: plan.env,
+		CpuTimeLimitMs: plan.limit.CPUMs,
+		WallTimeLimitMs: plan.limit.WallMs,
+		MemoryBytesLimit: plan.limit.AS,
+		FileSizeLimit: plan.limit.FSize,
+		StdoutLimitBytes: plan.limit.Stdout,
+		StderrLimitBytes: plan.limit.Stderr,
+		StackLimitBytes: plan.limit.Stack,
+		Umask: plan.umask,
+		StdinFile: stdin_file,
+		Files: files,
+	})
+}
+
+
+// ========================================
+// resolves everything in the ticket as ExecTicket does, but nothing is executed
+func (ctx *Context) ValidateTicket(ticket *Ticket) *ValidationReport {
+	report := &ValidationReport{}
+	if ticket == nil {
+		report.addError("ticket", errors.New("ticket is nil"))
+		return report
+	}
+
+	// sources which reference missing blobs are reported, and others are still validated
+	if err := resolveBlobReferences(ctx.blobStore, collectTicketSources(ticket)); err != nil {
+		report.addError("sources", err)
+	}
+
+	//
+	proc_profile, err := ctx.procConfTable.Find(ticket.ProcId, ticket.ProcVersion)
+	if err != nil {
+		report.addError("proc", err)
+		return report
+	}
+	if err := ctx.checkResourceControl(proc_profile); err != nil {
+		report.addError("proc", err)
+	}
+
+	report.Sources = validateSourceLayout(report, "sources", proc_profile, ticket.Sources)
+	ctx.validateBuildPhases(report, "build_inst", proc_profile, ticket.BuildInst, CompileMode, LinkMode)
+
+	// judge programs
+	var checker_profile, interactor_profile *ProcProfile
+	if ticket.Checker != nil {
+		checker_profile = ctx.validateJudgeProgram(report, "checker", ticket.Checker, CheckerCompileMode, CheckerLinkMode)
+		report.CheckerSources = []string{}
+		if checker_profile != nil {
+			report.CheckerSources = validateSourceLayout(report, "checker.sources", checker_profile, ticket.Checker.Sources)
+		}
+	}
+	if ticket.Interactor != nil {
+		interactor_profile = ctx.validateJudgeProgram(report, "interactor", ticket.Interactor, InteractorCompileMode, InteractorLinkMode)
+		report.InteractorSources = []string{}
+		if interactor_profile != nil {
+			report.InteractorSources = validateSourceLayout(report, "interactor.sources", interactor_profile, ticket.Interactor.Sources)
+		}
+	}
+
+	//
+	if ticket.RunInst == nil {
+		report.addError("run_inst", errors.New("run_inst is nil"))
+		return report
+	}
+	// data files can not replace files in the workspace
+	workspace_names := append(append([]string{}, report.Sources...), makeBuildOutputNames(proc_profile)...)
+
+	for index := range ticket.RunInst.Inputs {
+		input := &ticket.RunInst.Inputs[index]
+		field := fmt.Sprintf("run_inst.inputs[%d]", index)
+
+		setting, err := makeInputSetting(proc_profile, input)
+		if err != nil {
+			report.addError(field + ".args", err)
+			continue
+		}
+
+		// data files
+		var files []string
+		if contents, err := convertSourcesToContents(input.files); err != nil {
+			report.addError(field + ".files", err)
+		} else {
+			for _, content := range contents {
+				if err := validateDataFileName(content.Name, append(workspace_names, files...)); err != nil {
+					report.addError(field + ".files", err)
+					continue
+				}
+				files = append(files, content.Name)
+			}
+		}
+
+		// stdin is connected to the interactor instead of the file
+		stdin_file := ""
+		if input.stdin != nil && ticket.Interactor == nil {
+			if content, err := convertSourceToContent(input.stdin); err != nil {
+				report.addError(field + ".stdin", err)
+			} else {
+				stdin_file = path.Join(inputsDirName, content.Name)
+			}
+		}
+
+		report.addPhase(field, proc_profile, ctx.maxLimits, RunMode, RunMode, index, setting, stdin_file, files)
+
+		// judge programs are run with files of the input
+		if checker_profile != nil && ticket.Interactor == nil {
+			judge_files := []*TextContent{
+				&TextContent{ Name: judgeInputName },
+				&TextContent{ Name: judgeOutputName },
+				&TextContent{ Name: judgeAnswerName },
+			}
+			report.addPhase("checker.run_setting", checker_profile, ctx.maxLimits, RunMode, CheckerRunMode, index, makeJudgeRunSetting(ticket.Checker.RunSetting, judge_files), "", makeJudgeFilePaths(judge_files))
+		}
+		if interactor_profile != nil {
+			judge_files := []*TextContent{
+				&TextContent{ Name: judgeInputName },
+				&TextContent{ Name: judgeAnswerName },
+			}
+			report.addPhase("interactor.run_setting", interactor_profile, ctx.maxLimits, RunMode, InteractorRunMode, index, makeJudgeRunSetting(ticket.Interactor.RunSetting, judge_files), "", makeJudgeFilePaths(judge_files))
+		}
+	}
+
+	return report
+}
+
+func (ctx *Context) validateJudgeProgram(
+	report				*ValidationReport,
+	field				string,
+	program				*JudgeProgram,
+	compile_mode		int,
+	link_mode			int,
+) *ProcProfile {
+	proc_profile, err := ctx.procConfTable.Find(program.ProcId, program.ProcVersion)
+	if err != nil {
+		report.addError(field + ".proc", err)
+		return nil
+	}
+	if err := ctx.checkResourceControl(proc_profile); err != nil {
+		report.addError(field + ".proc", err)
+	}
+
+	ctx.validateBuildPhases(report, field + ".build_inst", proc_profile, program.BuildInst, compile_mode, link_mode)
+
+	return proc_profile
+}
+
+func (ctx *Context) validateBuildPhases(
+	report				*ValidationReport,
+	field				string,
+	proc_profile		*ProcProfile,
+	build_inst			*BuildInstruction,
+	compile_mode		int,
+	link_mode			int,
+) {
+	if !proc_profile.IsBuildRequired {
+		return
+	}
+	if build_inst == nil {
+		report.addError(field, errors.New("build_inst is nil"))
+		return
+	}
+
+	report.addPhase(field + ".compile_setting", proc_profile, ctx.maxLimits, CompileMode, compile_mode, 0, build_inst.CompileSetting, "", nil)
+	if proc_profile.IsLinkIndependent {
+		report.addPhase(field + ".link_setting", proc_profile, ctx.maxLimits, LinkMode, link_mode, 0, build_inst.LinkSetting, "", nil)
+	}
+}
+
+// names of files which would be placed by mapSources
+func validateSourceLayout(
+	report				*ValidationReport,
+	field				string,
+	proc_profile		*ProcProfile,
+	sources				[]*SourceData,
+) []string {
+	layout := []string{}
+	if len(sources) == 0 {
+		report.addError(field, errors.New("inputs that length is 0 can not be accepted"))
+		return layout
+	}
+
+	source_contents, err := convertSourcesToContents(sources)
+	if err != nil {
+		report.addError(field, err)
+		return layout
+	}
+
+	//
+	default_filename := fmt.Sprintf("%s.%s", proc_profile.Source.File, proc_profile.Source.Extension)
+	is_placed := map[string]bool{}
+	for _, content := range source_contents {
+		source_name, err := resolveSourceName(content.Name, &default_filename)
+		if err != nil {
+			report.addError(field, err)
+			continue
+		}
+		if is_placed[source_name] {
+			report.addError(field, errors.New(fmt.Sprintf("source name %s is duplicated", source_name)))
+			continue
+		}
+		is_placed[source_name] = true
+
+		layout = append(layout, source_name)
+	}
+
+	return layout
+}
@@ -0,0 +1,124 @@
+//
+// Copyright yutopp 2014 - .
+//
+// Distributed under the Boost Software License, Version 1.0.
+// (See accompanying file LICENSE_1_0.txt or copy at
+// http://www.boost.org/LICENSE_1_0.txt)
+//
+
+// +build linux
+
+package torigoya
+
+import (
+	"testing"
+	"reflect"
+)
+
+
+func TestUnitValidateTicket(t *testing.T) {
+	ctx := &Context{
+		procConfTable: ProcConfigTable{
+			0: ProcConfigUnit{
+				Versioned: map[string]ProcProfile{
+					"test": ProcProfile{
+						Version: "test",
+						IsBuildRequired: true,
+						Source: PhaseDetail{ File: "prog", Extension: "cpp" },
+						Compile: PhaseDetail{
+							File: "prog",
+							Extension: "out",
+							Command: "g++",
+							Env: map[string]string{ "PATH": "/usr/bin" },
+							AllowedCommandLine: map[string]SelectableCommand{
+								"-std=": SelectableCommand{ Select: []string{ "c++11" } },
+							},
+							FixedCommandLine: [][]string{ []string{ "-o ", "prog.out" } },
+						},
+						Run: PhaseDetail{
+							Command: "./prog.out",
+							AllowedCommandLine: map[string]SelectableCommand{
+								"--fast": SelectableCommand{},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	ticket := &Ticket{
+		BaseName: "aaa",
+		ProcId: 0,
+		ProcVersion: "test",
+		Sources: []*SourceData{ &SourceData{ Name: "*default*", Data: []byte("int main() {}") } },
+		BuildInst: &BuildInstruction{
+			CompileSetting: &ExecutionSetting{
+				CommandLine: "prog.cpp",
+				StructuredCommand: [][]string{ []string{ "-std=", "c++11" } },
+				CpuTimeLimit: 10,
+				MemoryBytesLimit: 1024,
+			},
+		},
+		RunInst: &RunInstruction{
+			Inputs: []Input{
+				Input{
+					stdin: &SourceData{ Name: "in", Data: []byte("1 2") },
+					setting: &ExecutionSetting{ CpuTimeLimit: 1, MemoryBytesLimit: 512 },
+					args: [][]string{ []string{ "--fast" } },
+					files: []*SourceData{ &SourceData{ Name: "data.txt" } },
+				},
+				Input{
+					setting: &ExecutionSetting{ CpuTimeLimit: 1, MemoryBytesLimit: 512 },
+					args: [][]string{ []string{ "--slow" } },
+				},
+			},
+		},
+	}
+
+	report := ctx.ValidateTicket(ticket)
+	if report.IsValid() {
+		t.Fatalf("invalid arguments should be reported")
+	}
+	if len(report.Errors) != 1 || report.Errors[0].Field != "run_inst.inputs[1].args" {
+		t.Fatalf("only arguments of the input 1 should be reported (but %v)", report.Errors)
+	}
+
+	if !reflect.DeepEqual(report.Sources, []string{ "prog.cpp" }) {
+		t.Fatalf("default name should be resolved (but %v)", report.Sources)
+	}
+
+	if len(report.Phases) != 2 {
+		t.Fatalf("compile and run phases should be reported (but %d)", len(report.Phases))
+	}
+	compile := report.Phases[0]
+	if compile.Mode != CompileMode || !reflect.DeepEqual(compile.Args, []string{ "g++", "-std=c++11", "-o", "prog.out", "prog.cpp" }) {
+		t.Fatalf("unexpected compile phase (%v)", compile)
+	}
+	if compile.Env["PATH"] != "/usr/bin" || compile.CpuTimeLimitMs != 10000 || compile.Umask != 0077 {
+		t.Fatalf("unexpected compile phase (%v)", compile)
+	}
+
+	run := report.Phases[1]
+	if run.Mode != RunMode || run.Index != 0 || !reflect.DeepEqual(run.Args, []string{ "./prog.out", "--fast" }) {
+		t.Fatalf("unexpected run phase (%v)", run)
+	}
+	if run.StdinFile != "stdin/in" || !reflect.DeepEqual(run.Files, []string{ "data.txt" }) || run.MemoryBytesLimit != 512 {
+		t.Fatalf("unexpected run phase (%v)", run)
+	}
+
+	// data files can not replace files in the workspace
+	ticket.RunInst.Inputs[1].args = nil
+	ticket.RunInst.Inputs[1].files = []*SourceData{ &SourceData{ Name: "prog.out" }, &SourceData{ Name: "prog.cpp" } }
+	report = ctx.ValidateTicket(ticket)
+	if len(report.Errors) != 2 || report.Errors[0].Field != "run_inst.inputs[1].files" || report.Errors[1].Field != "run_inst.inputs[1].files" {
+		t.Fatalf("data files which conflict with the workspace should be reported (but %v)", report.Errors)
+	}
+
+	// unknown profiles
+	ticket.ProcVersion = "unknown"
+	report = ctx.ValidateTicket(ticket)
+	if len(report.Errors) != 1 || report.Errors[0].Field != "proc" {
+		t.Fatalf("unknown profile should be reported (but %v)", report.Errors)
+	}
+}